@@ -0,0 +1,77 @@
+package ad
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// accountNeverExpires is the value of account_expires of accounts without an
+// expiration date.
+const accountNeverExpires = "never"
+
+// fileTimeUnixEpoch is the FILETIME of 1970-01-01, FILETIMEs count the 100ns
+// intervals since 1601-01-01 UTC.
+const fileTimeUnixEpoch = 116444736000000000
+
+// parseAccountExpires converts an RFC 3339 timestamp or "never" to the
+// FILETIME stored in accountExpires.
+func parseAccountExpires(value string) (string, error) {
+	if value == accountNeverExpires {
+		return strconv.FormatInt(math.MaxInt64, 10), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", fmt.Errorf("expected an RFC 3339 timestamp like 2006-01-02T15:04:05Z or %q, got %q", accountNeverExpires, value)
+	}
+	if t.Year() < 1601 || t.Year() > 30000 {
+		return "", fmt.Errorf("%s is out of the range of accountExpires", value)
+	}
+	fileTime := t.Unix()*10000000 + int64(t.Nanosecond()/100) + fileTimeUnixEpoch
+	return strconv.FormatInt(fileTime, 10), nil
+}
+
+// formatAccountExpires converts the FILETIME stored in accountExpires to an
+// RFC 3339 timestamp in UTC. AD uses both 0 and the largest FILETIME for
+// accounts which never expire.
+func formatAccountExpires(value string) (string, error) {
+	if value == "" {
+		return accountNeverExpires, nil
+	}
+	fileTime, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid accountExpires %q", value)
+	}
+	if fileTime == 0 || fileTime == math.MaxInt64 {
+		return accountNeverExpires, nil
+	}
+	intervals := fileTime - fileTimeUnixEpoch
+	t := time.Unix(intervals/10000000, intervals%10000000*100)
+	return t.UTC().Format(time.RFC3339Nano), nil
+}
+
+// validateAccountExpires checks that a value is an RFC 3339 timestamp or
+// "never".
+func validateAccountExpires(v interface{}, k string) ([]string, []error) {
+	if _, err := parseAccountExpires(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%q: %s", k, err)}
+	}
+	return nil, nil
+}
+
+// suppressEquivalentAccountExpires suppresses diffs between timestamps of the
+// same instant, e.g. in different time zones.
+func suppressEquivalentAccountExpires(k, old, new string, d *schema.ResourceData) bool {
+	oldFileTime, err := parseAccountExpires(old)
+	if err != nil {
+		return false
+	}
+	newFileTime, err := parseAccountExpires(new)
+	if err != nil {
+		return false
+	}
+	return oldFileTime == newFileTime
+}
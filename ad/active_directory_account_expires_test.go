@@ -0,0 +1,66 @@
+package ad
+
+import "testing"
+
+func TestAccountExpires(t *testing.T) {
+	for _, c := range []struct {
+		value    string
+		fileTime string
+	}{
+		{"never", "9223372036854775807"},
+		{"1970-01-01T00:00:00Z", "116444736000000000"},
+		{"2027-01-01T00:00:00Z", "134432352000000000"},
+		{"2027-01-01T12:30:00.5Z", "134432802005000000"},
+		{"1601-01-01T00:00:00Z", "0"},
+	} {
+		fileTime, err := parseAccountExpires(c.value)
+		if err != nil {
+			t.Fatalf("%s: %s", c.value, err)
+		}
+		if fileTime != c.fileTime {
+			t.Errorf("%s: expected %s, got %s", c.value, c.fileTime, fileTime)
+		}
+		if c.fileTime == "0" {
+			// 0 means never as well
+			continue
+		}
+		value, err := formatAccountExpires(fileTime)
+		if err != nil {
+			t.Fatalf("%s: %s", fileTime, err)
+		}
+		if value != c.value {
+			t.Errorf("%s: expected %s, got %s", fileTime, c.value, value)
+		}
+	}
+
+	for _, fileTime := range []string{"", "0"} {
+		if value, err := formatAccountExpires(fileTime); err != nil || value != "never" {
+			t.Errorf("%q: expected never, got %s, %v", fileTime, value, err)
+		}
+	}
+	if _, err := formatAccountExpires("soon"); err == nil {
+		t.Error("expected an error for an invalid FILETIME")
+	}
+	for _, value := range []string{"", "2027-01-01", "tomorrow", "1500-01-01T00:00:00Z"} {
+		if _, errs := validateAccountExpires(value, "account_expires"); len(errs) == 0 {
+			t.Errorf("expected %q to be invalid", value)
+		}
+	}
+}
+
+func TestSuppressEquivalentAccountExpires(t *testing.T) {
+	for _, c := range []struct {
+		old, new string
+		suppress bool
+	}{
+		{"2027-01-01T00:00:00Z", "2027-01-01T02:00:00+02:00", true},
+		{"2027-01-01T00:00:00Z", "2027-01-01T00:00:00+02:00", false},
+		{"never", "never", true},
+		{"never", "2027-01-01T00:00:00Z", false},
+		{"2027-01-01T00:00:00Z", "invalid", false},
+	} {
+		if suppress := suppressEquivalentAccountExpires("account_expires", c.old, c.new, nil); suppress != c.suppress {
+			t.Errorf("%s, %s: expected %t, got %t", c.old, c.new, c.suppress, suppress)
+		}
+	}
+}
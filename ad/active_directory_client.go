@@ -0,0 +1,400 @@
+package ad
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// adClient is the set of LDAP operations used by the resources and data
+// sources. It is implemented by *ldap.Conn, by the reconnectingClient and by
+// the connectionPool the provider hands out.
+type adClient interface {
+	Add(*ldap.AddRequest) error
+	Del(*ldap.DelRequest) error
+	Modify(*ldap.ModifyRequest) error
+	ModifyDN(*ldap.ModifyDNRequest) error
+	Search(*ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+// healthCheckIdleTime is how long a connection may be idle before it is
+// checked ahead of its next use. Domain controllers drop idle connections
+// after 15 minutes by default (MaxConnIdleTime).
+const healthCheckIdleTime = time.Minute
+
+// connectFunc opens a bound connection to one of the AD servers, trying the
+// preferred one first if set, and returns the server it connected to.
+type connectFunc func(preferred string) (*ldap.Conn, string, error)
+
+// reconnectingClient keeps a connection to AD and transparently re-dials and
+// re-binds if it was closed, for example by the server after being idle. It
+// reconnects to the same server if possible, so that it keeps seeing its
+// own changes.
+type reconnectingClient struct {
+	connect connectFunc
+
+	lock     sync.Mutex
+	conn     *ldap.Conn
+	server   string
+	lastUsed time.Time
+}
+
+func newReconnectingClient(connect connectFunc) (*reconnectingClient, error) {
+	conn, server, err := connect("")
+	if err != nil {
+		return nil, err
+	}
+	return &reconnectingClient{connect: connect, conn: conn, server: server, lastUsed: time.Now()}, nil
+}
+
+// current returns a connection that is believed to be usable, reconnecting
+// if the current one was closed or fails its health check.
+func (c *reconnectingClient) current() (*ldap.Conn, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn != nil && !c.conn.IsClosing() && time.Since(c.lastUsed) > healthCheckIdleTime {
+		if err := healthCheck(c.conn); err != nil {
+			log.Printf("[WARN] Health check of the idle AD connection failed: %s", err)
+			c.conn.Close()
+		}
+	}
+
+	if c.conn == nil || c.conn.IsClosing() {
+		log.Printf("[DEBUG] AD connection to %s was closed, reconnecting", c.server)
+		conn, server, err := c.connect(c.server)
+		if err != nil {
+			return nil, err
+		}
+		if server != c.server {
+			log.Printf("[WARN] Reconnected to %s instead of %s, recent changes may not be visible yet", server, c.server)
+		}
+		c.conn = conn
+		c.server = server
+	}
+	c.lastUsed = time.Now()
+	return c.conn, nil
+}
+
+// discard closes the connection after it was found broken, unless it was
+// already replaced by another operation.
+func (c *reconnectingClient) discard(conn *ldap.Conn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn == conn {
+		conn.Close()
+	}
+}
+
+// do runs the operation and, if the connection turns out to be lost, runs it
+// once more on a new connection. Operations which are not idempotent are only
+// repeated if the request never left the client. A request which timed out
+// is neither repeated nor does it cost the connection, the server is slow
+// rather than gone and may still apply it.
+func (c *reconnectingClient) do(idempotent bool, op func(conn *ldap.Conn) error) error {
+	conn, err := c.current()
+	if err != nil {
+		return err
+	}
+
+	err = op(conn)
+	if requestTimedOut(err) {
+		log.Printf("[WARN] AD server did not answer the request in time, keeping the connection: %s", err)
+		return fmt.Errorf("the AD server did not answer within the request_timeout, the request may still be applied: %s", err)
+	}
+	if !ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		return err
+	}
+	if !conn.IsClosing() {
+		// the ldap package reports some failures of single requests, like
+		// the response channel of a timed out request being closed, as
+		// network errors although the connection still works
+		log.Printf("[WARN] AD request failed on a working connection, not retrying it: %s", err)
+		return err
+	}
+	c.discard(conn)
+	if !idempotent && !requestNotSent(err) {
+		log.Printf("[WARN] AD connection was lost during the request, not retrying it as it may have been applied: %s", err)
+		return err
+	}
+
+	log.Printf("[DEBUG] AD connection was lost, retrying the request on a new connection: %s", err)
+	conn, err = c.current()
+	if err != nil {
+		return err
+	}
+	return op(conn)
+}
+
+// requestTimedOut reports whether the ldap package gave up waiting for the
+// answer to a request after the request timeout.
+func requestTimedOut(err error) bool {
+	if ldapErr, ok := err.(*ldap.Error); ok {
+		err = ldapErr.Err
+	}
+	return err != nil && err.Error() == "ldap: connection timed out"
+}
+
+// requestNotSent reports whether the ldap package refused to send a request
+// because the connection was already closed.
+func requestNotSent(err error) bool {
+	ldapErr, ok := err.(*ldap.Error)
+	return ok && ldapErr.Err != nil && ldapErr.Err.Error() == "ldap: connection closed"
+}
+
+// healthCheck reads the root DSE to verify the connection still works. Any
+// answer of the server, even an error, proves that.
+func healthCheck(conn *ldap.Conn) error {
+	searchRequest := ldap.NewSearchRequest(
+		"", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		filterPresent("objectClass"), []string{"currentTime"}, nil,
+	)
+	if _, err := conn.Search(searchRequest); ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		return err
+	}
+	return nil
+}
+
+func (c *reconnectingClient) Add(request *ldap.AddRequest) error {
+	return c.do(false, func(conn *ldap.Conn) error {
+		return conn.Add(request)
+	})
+}
+
+func (c *reconnectingClient) Del(request *ldap.DelRequest) error {
+	return c.do(false, func(conn *ldap.Conn) error {
+		return conn.Del(request)
+	})
+}
+
+func (c *reconnectingClient) Modify(request *ldap.ModifyRequest) error {
+	return c.do(false, func(conn *ldap.Conn) error {
+		return conn.Modify(request)
+	})
+}
+
+func (c *reconnectingClient) ModifyDN(request *ldap.ModifyDNRequest) error {
+	return c.do(false, func(conn *ldap.Conn) error {
+		return conn.ModifyDN(request)
+	})
+}
+
+func (c *reconnectingClient) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	var result *ldap.SearchResult
+	err := c.do(true, func(conn *ldap.Conn) error {
+		var err error
+		result, err = conn.Search(request)
+		return err
+	})
+	return result, err
+}
+
+func (c *reconnectingClient) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// connectionPool is an adClient spreading operations over up to max
+// connections, so that parallel resource operations do not wait for each
+// other. Connections are opened on demand and kept for reuse.
+type connectionPool struct {
+	connect connectFunc
+
+	// idle holds the connections not in use, slots one token per open connection
+	idle  chan *reconnectingClient
+	slots chan struct{}
+}
+
+// newConnectionPool returns a pool of at most max connections. The first
+// connection is opened right away to report configuration errors early.
+func newConnectionPool(max int, connect connectFunc) (*connectionPool, error) {
+	if max < 1 {
+		max = 1
+	}
+	p := &connectionPool{
+		connect: connect,
+		idle:    make(chan *reconnectingClient, max),
+		slots:   make(chan struct{}, max),
+	}
+
+	client, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	p.put(client)
+	return p, nil
+}
+
+// get returns an idle connection, opens a new one if the limit was not
+// reached yet, or else waits for a connection to be returned.
+func (p *connectionPool) get() (*reconnectingClient, error) {
+	select {
+	case client := <-p.idle:
+		return client, nil
+	default:
+	}
+
+	select {
+	case client := <-p.idle:
+		return client, nil
+	case p.slots <- struct{}{}:
+		client, err := newReconnectingClient(p.connect)
+		if err != nil {
+			<-p.slots
+			return nil, err
+		}
+		log.Printf("[DEBUG] Opened AD connection %d of %d", len(p.slots), cap(p.slots))
+		return client, nil
+	}
+}
+
+func (p *connectionPool) put(client *reconnectingClient) {
+	p.idle <- client
+}
+
+// with runs the operation on a connection of the pool.
+func (p *connectionPool) with(op func(client *reconnectingClient) error) error {
+	client, err := p.get()
+	if err != nil {
+		return err
+	}
+	defer p.put(client)
+	return op(client)
+}
+
+func (p *connectionPool) Add(request *ldap.AddRequest) error {
+	return p.with(func(client *reconnectingClient) error {
+		return client.Add(request)
+	})
+}
+
+func (p *connectionPool) Del(request *ldap.DelRequest) error {
+	return p.with(func(client *reconnectingClient) error {
+		return client.Del(request)
+	})
+}
+
+func (p *connectionPool) Modify(request *ldap.ModifyRequest) error {
+	return p.with(func(client *reconnectingClient) error {
+		return client.Modify(request)
+	})
+}
+
+func (p *connectionPool) ModifyDN(request *ldap.ModifyDNRequest) error {
+	return p.with(func(client *reconnectingClient) error {
+		return client.ModifyDN(request)
+	})
+}
+
+func (p *connectionPool) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	var result *ldap.SearchResult
+	err := p.with(func(client *reconnectingClient) error {
+		var err error
+		result, err = client.Search(request)
+		return err
+	})
+	return result, err
+}
+
+// Close closes the idle connections of the pool.
+func (p *connectionPool) Close() {
+	for {
+		select {
+		case client := <-p.idle:
+			client.Close()
+		default:
+			return
+		}
+	}
+}
+
+// deadlineClient is an adClient failing operations which do not complete
+// before the deadline of the resource operation they belong to. An operation
+// given up on keeps its connection until the request timeout ends it.
+type deadlineClient struct {
+	client   adClient
+	timeout  time.Duration
+	deadline time.Time
+}
+
+// timeoutClient returns the provider client bounded by the given timeout of
+// the resource, e.g. schema.TimeoutCreate.
+func timeoutClient(d *schema.ResourceData, meta interface{}, key string) adClient {
+	timeout := d.Timeout(key)
+	return &deadlineClient{
+		client:   meta.(adClient),
+		timeout:  timeout,
+		deadline: time.Now().Add(timeout),
+	}
+}
+
+// do runs the operation unless the deadline passed and stops waiting for it
+// once the deadline is reached.
+func (c *deadlineClient) do(op func() error) error {
+	remaining := time.Until(c.deadline)
+	if remaining <= 0 {
+		return fmt.Errorf("timeout after %s", c.timeout)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- op()
+	}()
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("timeout after %s", c.timeout)
+	}
+}
+
+func (c *deadlineClient) Add(request *ldap.AddRequest) error {
+	return c.do(func() error {
+		return c.client.Add(request)
+	})
+}
+
+func (c *deadlineClient) Del(request *ldap.DelRequest) error {
+	return c.do(func() error {
+		return c.client.Del(request)
+	})
+}
+
+func (c *deadlineClient) Modify(request *ldap.ModifyRequest) error {
+	return c.do(func() error {
+		return c.client.Modify(request)
+	})
+}
+
+func (c *deadlineClient) ModifyDN(request *ldap.ModifyDNRequest) error {
+	return c.do(func() error {
+		return c.client.ModifyDN(request)
+	})
+}
+
+func (c *deadlineClient) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	var result *ldap.SearchResult
+	err := c.do(func() error {
+		var err error
+		result, err = c.client.Search(request)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Close does nothing, the provider client outlives the resource operation.
+func (c *deadlineClient) Close() {}
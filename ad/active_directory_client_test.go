@@ -0,0 +1,222 @@
+package ad
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+func testReconnectingClient(t *testing.T, server *testLDAPServer) (*reconnectingClient, *ldap.Conn) {
+	connector := &connector{transport: transportPlain, ldapPort: 389, username: "admin@example.com", password: "secret"}
+	client, err := newReconnectingClient(func(preferred string) (*ldap.Conn, string, error) {
+		return connectFirst([]string{server.Addr()}, connector)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return client, client.conn
+}
+
+// waitClosing waits until the client noticed that the server closed the connection.
+func waitClosing(t *testing.T, conn *ldap.Conn) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !conn.IsClosing() {
+		if time.Now().After(deadline) {
+			t.Fatal("connection was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconnectingClient_closedConnection(t *testing.T) {
+	server := newTestLDAPServer(t)
+	defer server.Close()
+
+	client, conn := testReconnectingClient(t, server)
+	defer client.Close()
+
+	server.Disconnect()
+	waitClosing(t, conn)
+
+	// the server refuses every operation, getting its answer proves the reconnect
+	err := client.Add(ldap.NewAddRequest("cn=test,dc=example,dc=com", nil))
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+		t.Fatalf("expected the request to be answered, got %v", err)
+	}
+	if server.Binds() != 2 {
+		t.Fatalf("expected a second bind, got %d binds", server.Binds())
+	}
+}
+
+func TestReconnectingClient_retry(t *testing.T) {
+	server := newTestLDAPServer(t)
+	defer server.Close()
+
+	client, conn := testReconnectingClient(t, server)
+	defer client.Close()
+
+	server.Disconnect()
+	waitClosing(t, conn)
+
+	// the connection closes after the check in current()
+	calls := 0
+	err := client.do(false, func(c *ldap.Conn) error {
+		calls++
+		if calls == 1 {
+			c.Close()
+		}
+		return c.Del(ldap.NewDelRequest("cn=test,dc=example,dc=com", nil))
+	})
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) || calls != 2 {
+		t.Fatalf("expected the unsent request to be retried once, got %v after %d calls", err, calls)
+	}
+
+	// the connection broke after the request was sent
+	lost := ldap.NewError(ldap.ErrorNetwork, errors.New("ldap: response channel closed"))
+
+	calls = 0
+	err = client.do(false, func(c *ldap.Conn) error {
+		calls++
+		c.Close()
+		return lost
+	})
+	if err != lost || calls != 1 {
+		t.Fatalf("expected a sent write not to be retried, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = client.do(true, func(c *ldap.Conn) error {
+		calls++
+		if calls == 1 {
+			c.Close()
+			return lost
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("expected a read to be retried once, got %v after %d calls", err, calls)
+	}
+}
+
+func TestReconnectingClient_timeout(t *testing.T) {
+	server := newTestLDAPServer(t)
+	defer server.Close()
+	var adds int32
+	server.Add = func(request *ldap.AddRequest) uint16 {
+		atomic.AddInt32(&adds, 1)
+		time.Sleep(200 * time.Millisecond)
+		return ldap.LDAPResultSuccess
+	}
+
+	client, conn := testReconnectingClient(t, server)
+	defer client.Close()
+	conn.SetTimeout(50 * time.Millisecond)
+
+	// a slow answer is neither repeated nor does it cost the connection
+	err := client.Add(ldap.NewAddRequest("cn=test,dc=example,dc=com", nil))
+	if err == nil || !strings.Contains(err.Error(), "request_timeout") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if conn.IsClosing() || client.conn != conn {
+		t.Fatal("expected the connection to be kept")
+	}
+
+	// a network error on a working connection does not cost it either
+	lost := ldap.NewError(ldap.ErrorNetwork, errors.New("ldap: response channel closed"))
+	calls := 0
+	err = client.do(true, func(c *ldap.Conn) error {
+		calls++
+		return lost
+	})
+	if err != lost || calls != 1 || conn.IsClosing() {
+		t.Fatalf("expected the error to be returned on the kept connection, got %v after %d calls", err, calls)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if atomic.LoadInt32(&adds) != 1 || server.Binds() != 1 {
+		t.Fatalf("expected a single add on a single connection, got %d adds and %d binds", adds, server.Binds())
+	}
+}
+
+func TestConnectionPool(t *testing.T) {
+	server := newTestLDAPServer(t)
+	defer server.Close()
+
+	connector := &connector{transport: transportPlain, ldapPort: 389, username: "admin@example.com", password: "secret"}
+	pool, err := newConnectionPool(2, func(preferred string) (*ldap.Conn, string, error) {
+		return connectFirst([]string{server.Addr()}, connector)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Close()
+
+	first, err := pool.get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	second, err := pool.get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first == second || server.Binds() != 2 {
+		t.Fatalf("expected two connections, got %d binds", server.Binds())
+	}
+
+	// all connections are in use, the next operation has to wait
+	done := make(chan error)
+	go func() {
+		done <- pool.Del(ldap.NewDelRequest("cn=test,dc=example,dc=com", nil))
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected the operation to wait for a connection, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pool.put(first)
+	if err := <-done; !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+		t.Fatalf("expected the request to be answered, got %v", err)
+	}
+	pool.put(second)
+
+	if server.Binds() != 2 {
+		t.Fatalf("expected the connections to be reused, got %d binds", server.Binds())
+	}
+}
+
+// blockingClient is an adClient whose searches hang until released.
+type blockingClient struct {
+	adClient
+	release chan struct{}
+}
+
+func (c *blockingClient) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	<-c.release
+	return &ldap.SearchResult{}, nil
+}
+
+func TestDeadlineClient(t *testing.T) {
+	blocking := &blockingClient{release: make(chan struct{})}
+	defer close(blocking.release)
+
+	client := &deadlineClient{client: blocking, timeout: 50 * time.Millisecond, deadline: time.Now().Add(50 * time.Millisecond)}
+	request := ldap.NewSearchRequest("dc=example,dc=com", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil)
+
+	start := time.Now()
+	if _, err := client.Search(request); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the search to be given up after the deadline, took %s", elapsed)
+	}
+
+	// once the deadline passed operations are not even started
+	if err := client.Add(ldap.NewAddRequest("cn=test,dc=example,dc=com", nil)); err == nil {
+		t.Fatal("expected a timeout")
+	}
+}
@@ -2,26 +2,32 @@ package ad
 
 import ldap "gopkg.in/ldap.v3"
 
-func addComputerToAD(computerName string, dnName string, adConn *ldap.Conn, desc string) error {
+// computerAccountName returns the sAMAccountName of a computer, its name
+// followed by $.
+func computerAccountName(computerName string) string {
+	return computerName + "$"
+}
+
+func addComputerToAD(computerName string, dnName string, adConn adClient, desc string) error {
 	addRequest := ldap.NewAddRequest(dnName, nil)
 	addRequest.Attribute("objectClass", []string{"computer"})
-	addRequest.Attribute("sAMAccountName", []string{computerName})
+	addRequest.Attribute("sAMAccountName", []string{computerAccountName(computerName)})
 	addRequest.Attribute("userAccountControl", []string{"4096"})
 	if desc != "" {
 		addRequest.Attribute("description", []string{desc})
 	}
 	err := adConn.Add(addRequest)
 	if err != nil {
-		return err
+		return describeADError(err, dnName, "")
 	}
 	return nil
 }
 
-func deleteComputerFromAD(dnName string, adConn *ldap.Conn) error {
+func deleteComputerFromAD(dnName string, adConn adClient) error {
 	delRequest := ldap.NewDelRequest(dnName, nil)
 	err := adConn.Del(delRequest)
 	if err != nil {
-		return err
+		return describeADError(err, dnName, "")
 	}
 	return nil
 }
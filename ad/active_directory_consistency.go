@@ -0,0 +1,148 @@
+package ad
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+// sessionClient is implemented by clients which can hand out a client using
+// a single connection, and thereby a single DC, until it is closed.
+type sessionClient interface {
+	session() adClient
+}
+
+// pinClient returns a client bound to a single DC for the duration of a
+// resource operation, so that the reads following a write see it even before
+// it was replicated. It must be closed once the operation is done.
+func pinClient(meta interface{}) adClient {
+	if sessions, ok := meta.(sessionClient); ok {
+		return sessions.session()
+	}
+	return sharedClient{meta.(adClient)}
+}
+
+// sharedClient is an adClient whose Close leaves the underlying client open.
+type sharedClient struct {
+	adClient
+}
+
+func (sharedClient) Close() {}
+
+func (p *connectionPool) session() adClient {
+	return &pinnedClient{pool: p}
+}
+
+func (c *retryingClient) session() adClient {
+	return &retryingClient{client: pinClient(c.client), policy: c.policy}
+}
+
+// pinnedClient is an adClient holding on to one connection of the pool from
+// its first operation until it is closed.
+type pinnedClient struct {
+	pool *connectionPool
+
+	lock   sync.Mutex
+	client *reconnectingClient
+}
+
+func (c *pinnedClient) acquire() (*reconnectingClient, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.client == nil {
+		client, err := c.pool.get()
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+	}
+	return c.client, nil
+}
+
+func (c *pinnedClient) Add(request *ldap.AddRequest) error {
+	client, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	return client.Add(request)
+}
+
+func (c *pinnedClient) Del(request *ldap.DelRequest) error {
+	client, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	return client.Del(request)
+}
+
+func (c *pinnedClient) Modify(request *ldap.ModifyRequest) error {
+	client, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	return client.Modify(request)
+}
+
+func (c *pinnedClient) ModifyDN(request *ldap.ModifyDNRequest) error {
+	client, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	return client.ModifyDN(request)
+}
+
+func (c *pinnedClient) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	client, err := c.acquire()
+	if err != nil {
+		return nil, err
+	}
+	return client.Search(request)
+}
+
+// Close returns the connection to the pool.
+func (c *pinnedClient) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.client != nil {
+		c.pool.put(c.client)
+		c.client = nil
+	}
+}
+
+// entryPollInterval is the first pause between two checks for a new entry,
+// the pause doubles up to entryMaxPollInterval.
+var (
+	entryPollInterval    = 100 * time.Millisecond
+	entryMaxPollInterval = 2 * time.Second
+)
+
+// waitForEntry polls until the entry can be read, at most for the given
+// timeout, the create timeout of the resource. A new entry may not be
+// visible yet if the connection had to be re-established to another DC since
+// it was added.
+func waitForEntry(client adClient, dn string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	wait := entryPollInterval
+	for {
+		searchRequest := ldap.NewSearchRequest(
+			dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+			filterPresent("objectClass"), []string{"1.1"}, nil,
+		)
+		_, err := client.Search(searchRequest)
+		if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return err
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("%s did not become visible within %s", dn, timeout)
+		}
+
+		log.Printf("[DEBUG] %s is not visible yet, checking again in %s", dn, wait)
+		time.Sleep(wait)
+		if wait *= 2; wait > entryMaxPollInterval {
+			wait = entryMaxPollInterval
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package ad
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+func TestPinClient(t *testing.T) {
+	server := newTestLDAPServer(t)
+	defer server.Close()
+
+	connector := &connector{transport: transportPlain, ldapPort: 389, username: "admin@example.com", password: "secret"}
+	pool, err := newConnectionPool(2, func(preferred string) (*ldap.Conn, string, error) {
+		return connectFirst([]string{server.Addr()}, connector)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Close()
+	client := &retryingClient{client: pool, policy: &retryPolicy{maxAttempts: 1}}
+
+	session := pinClient(client)
+	request := ldap.NewDelRequest("cn=test,dc=example,dc=com", nil)
+	for i := 0; i < 3; i++ {
+		if err := session.Del(request); !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+			t.Fatalf("expected the request to be answered, got %v", err)
+		}
+	}
+	pinned := session.(*retryingClient).client.(*pinnedClient).client
+	if pinned == nil || len(pool.idle) != 0 {
+		t.Fatal("expected the session to hold on to its connection")
+	}
+
+	// a nested session shares the connection instead of taking another one
+	nested := pinClient(session)
+	nested.Del(request)
+	nested.Close()
+	if len(pool.idle) != 0 || server.Binds() != 1 {
+		t.Fatalf("expected the nested session to use the pinned connection, got %d binds", server.Binds())
+	}
+
+	session.Close()
+	if len(pool.idle) != 1 || <-pool.idle != pinned {
+		t.Fatal("expected the connection to be returned to the pool")
+	}
+}
+
+// invisibleClient is an adClient not finding entries for the first searches.
+type invisibleClient struct {
+	adClient
+	misses int
+}
+
+func (c *invisibleClient) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if c.misses > 0 {
+		c.misses--
+		return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, errors.New("no such object"))
+	}
+	return &ldap.SearchResult{}, nil
+}
+
+func TestWaitForEntry(t *testing.T) {
+	defer func(interval, maxInterval time.Duration) {
+		entryPollInterval, entryMaxPollInterval = interval, maxInterval
+	}(entryPollInterval, entryMaxPollInterval)
+	entryPollInterval = time.Millisecond
+	entryMaxPollInterval = 20 * time.Millisecond
+
+	client := &invisibleClient{misses: 3}
+	if err := waitForEntry(client, "cn=test,dc=example,dc=com", 200*time.Millisecond); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if client.misses != 0 {
+		t.Fatalf("expected to poll until the entry is visible, %d misses left", client.misses)
+	}
+
+	// the wait ends with the create timeout of the resource
+	client = &invisibleClient{misses: 1000}
+	start := time.Now()
+	err := waitForEntry(client, "cn=test,dc=example,dc=com", 200*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "200ms") {
+		t.Fatalf("expected an error if the entry never becomes visible, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected to give up after the timeout, waited %s", elapsed)
+	}
+}
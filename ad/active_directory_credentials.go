@@ -0,0 +1,94 @@
+package ad
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// processCredentials is the JSON document a credential_process prints.
+type processCredentials struct {
+	Version  int
+	Password string
+}
+
+// loadCredentials sets the password from the configured password_file or
+// credential_process. At most one source of the password may be configured.
+func (c *Config) loadCredentials() error {
+	sources := 0
+	for _, source := range []string{c.Password, c.PasswordFile, c.CredentialProcess} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of password, password_file and credential_process may be set")
+	}
+
+	switch {
+	case c.PasswordFile != "":
+		password, err := readPasswordFile(c.PasswordFile)
+		if err != nil {
+			return err
+		}
+		c.Password = password
+	case c.CredentialProcess != "":
+		credentials, err := runCredentialProcess(c.CredentialProcess)
+		if err != nil {
+			return err
+		}
+		c.Password = credentials.Password
+	}
+	return nil
+}
+
+// readPasswordFile returns the content of a file holding only the password,
+// like a mounted secret, without trailing line breaks.
+func readPasswordFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read password_file: %s", err)
+	}
+	password := strings.TrimRight(string(content), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password_file %s is empty", path)
+	}
+	log.Printf("[DEBUG] Read the AD password from %s", path)
+	return password, nil
+}
+
+// runCredentialProcess runs the command through the shell and parses the
+// credentials it prints to stdout.
+func runCredentialProcess(command string) (*processCredentials, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	log.Printf("[DEBUG] Running credential_process to get the AD credentials")
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential_process failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var credentials processCredentials
+	if err := json.Unmarshal(stdout.Bytes(), &credentials); err != nil {
+		return nil, fmt.Errorf("credential_process did not print valid JSON: %s", err)
+	}
+	if credentials.Version != 1 {
+		return nil, fmt.Errorf("unsupported credential_process output version %d, expected 1", credentials.Version)
+	}
+	if credentials.Password == "" {
+		return nil, fmt.Errorf("credential_process did not print a Password")
+	}
+	return &credentials, nil
+}
@@ -0,0 +1,66 @@
+package ad
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigLoadCredentials_file(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ad-credentials")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(path, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	config := Config{PasswordFile: path}
+	if err := config.loadCredentials(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if config.Password != "s3cr3t" {
+		t.Fatalf("unexpected password %q", config.Password)
+	}
+
+	config = Config{PasswordFile: filepath.Join(dir, "missing")}
+	if err := config.loadCredentials(); err == nil {
+		t.Fatal("expected an error for a missing password file")
+	}
+}
+
+func TestConfigLoadCredentials_process(t *testing.T) {
+	config := Config{CredentialProcess: `echo '{"Version": 1, "Password": "s3cr3t"}'`}
+	if err := config.loadCredentials(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if config.Password != "s3cr3t" {
+		t.Fatalf("unexpected password %q", config.Password)
+	}
+
+	cases := map[string]string{
+		"echo 'denied' >&2; exit 1":              "denied",
+		"echo 'not json'":                        "valid JSON",
+		`echo '{"Version": 2, "Password": "x"}'`: "version",
+		`echo '{"Version": 1}'`:                  "Password",
+	}
+	for command, expected := range cases {
+		config := Config{CredentialProcess: command}
+		err := config.loadCredentials()
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("%s: expected error containing %q, got %v", command, expected, err)
+		}
+	}
+}
+
+func TestConfigLoadCredentials_conflict(t *testing.T) {
+	config := Config{Password: "secret", CredentialProcess: "echo"}
+	if err := config.loadCredentials(); err == nil {
+		t.Fatal("expected an error for more than one password source")
+	}
+}
@@ -0,0 +1,182 @@
+package ad
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// distinguishedName is a parsed DN. Unlike ldap.DN it compares attribute
+// types and values case-insensitively, as Active Directory does.
+type distinguishedName struct {
+	rdns []*ldap.RelativeDN
+}
+
+// parseDN parses a DN in the string representation of RFC 4514.
+func parseDN(dn string) (*distinguishedName, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DN %q: %s", dn, err)
+	}
+	return &distinguishedName{rdns: parsed.RDNs}, nil
+}
+
+// parseExtendedDN splits a DN in the extended form AD returns for the
+// extended DN control, <GUID=...>;<SID=...>;dn, into the hex encoded
+// objectGUID and the DN. Only security principals have the SID component. A
+// DN without the components is returned as it is with an empty GUID.
+func parseExtendedDN(dn string) (string, string) {
+	guid := ""
+	for strings.HasPrefix(dn, "<") {
+		end := strings.Index(dn, ">;")
+		if end < 0 {
+			break
+		}
+		component := dn[1:end]
+		if strings.HasPrefix(strings.ToUpper(component), "GUID=") {
+			guid = component[len("GUID="):]
+		}
+		dn = dn[end+2:]
+	}
+	return guid, dn
+}
+
+// String returns the DN with all values escaped as required by RFC 4514.
+func (d *distinguishedName) String() string {
+	rdns := make([]string, len(d.rdns))
+	for i, rdn := range d.rdns {
+		attributes := make([]string, len(rdn.Attributes))
+		for j, attribute := range rdn.Attributes {
+			attributes[j] = rdnString(attribute.Type, attribute.Value)
+		}
+		rdns[i] = strings.Join(attributes, "+")
+	}
+	return strings.Join(rdns, ",")
+}
+
+// Name returns the value of the first RDN, e.g. the common name of a user. If
+// the RDN is multi-valued, the value of its first attribute is returned.
+func (d *distinguishedName) Name() string {
+	if len(d.rdns) == 0 || len(d.rdns[0].Attributes) == 0 {
+		return ""
+	}
+	return d.rdns[0].Attributes[0].Value
+}
+
+// Parent returns the DN without its first RDN.
+func (d *distinguishedName) Parent() *distinguishedName {
+	if len(d.rdns) == 0 {
+		return d
+	}
+	return &distinguishedName{rdns: d.rdns[1:]}
+}
+
+// Domain returns the trailing dc= RDNs, i.e. the DN of the naming context the
+// entry belongs to. A DN without domain components is returned unchanged.
+func (d *distinguishedName) Domain() *distinguishedName {
+	start := len(d.rdns)
+	for start > 0 && isDomainComponent(d.rdns[start-1]) {
+		start--
+	}
+	if start == len(d.rdns) {
+		return d
+	}
+	return &distinguishedName{rdns: d.rdns[start:]}
+}
+
+// Equal reports whether both DNs name the same entry. The attributes of a
+// multi-valued RDN may appear in any order.
+func (d *distinguishedName) Equal(other *distinguishedName) bool {
+	if len(d.rdns) != len(other.rdns) {
+		return false
+	}
+	for i := range d.rdns {
+		if !rdnEqual(d.rdns[i], other.rdns[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDomainComponent(rdn *ldap.RelativeDN) bool {
+	return len(rdn.Attributes) == 1 && strings.EqualFold(rdn.Attributes[0].Type, "dc")
+}
+
+func rdnEqual(a, b *ldap.RelativeDN) bool {
+	if len(a.Attributes) != len(b.Attributes) {
+		return false
+	}
+	for _, x := range a.Attributes {
+		found := false
+		for _, y := range b.Attributes {
+			if strings.EqualFold(x.Type, y.Type) && strings.EqualFold(x.Value, y.Value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// rdnString returns the RDN attribute=value with the value escaped.
+func rdnString(attribute string, value string) string {
+	return attribute + "=" + escapeDNValue(value)
+}
+
+// childDN returns the DN of the entry named attribute=value below parent.
+func childDN(attribute string, value string, parent string) string {
+	if parent == "" {
+		return rdnString(attribute, value)
+	}
+	return rdnString(attribute, value) + "," + parent
+}
+
+// escapeDNValue escapes an attribute value for use in a DN, see RFC 4514
+// section 2.4.
+func escapeDNValue(value string) string {
+	var result strings.Builder
+	for i := 0; i < len(value); i++ {
+		char := value[i]
+		switch {
+		case char == 0:
+			result.WriteString(`\00`)
+			continue
+		case strings.IndexByte(`"+,;<>\=`, char) >= 0,
+			i == 0 && (char == ' ' || char == '#'),
+			i == len(value)-1 && char == ' ':
+			result.WriteByte('\\')
+		}
+		result.WriteByte(char)
+	}
+	return result.String()
+}
+
+// suppressEquivalentDN suppresses diffs between DNs which only differ in case
+// or escaping.
+func suppressEquivalentDN(k, old, new string, d *schema.ResourceData) bool {
+	oldDN, err := parseDN(old)
+	if err != nil {
+		return false
+	}
+	newDN, err := parseDN(new)
+	if err != nil {
+		return false
+	}
+	return oldDN.Equal(newDN)
+}
+
+// currentDN returns the DN of the object of a resource as read last. Objects
+// are modified, moved and deleted by it rather than by a DN built from the
+// configuration, which does not match objects named differently, e.g.
+// imported ones. The <GUID=...> DN is used if no DN was read yet.
+func currentDN(d *schema.ResourceData) string {
+	if dn := d.Get("dn").(string); dn != "" {
+		return dn
+	}
+	return guidBaseDN(d.Id())
+}
@@ -0,0 +1,107 @@
+package ad
+
+import "testing"
+
+func TestParseDN(t *testing.T) {
+	cases := []struct {
+		dn     string
+		name   string
+		parent string
+		domain string
+	}{
+		{`CN=O'Brien\, Pat,OU=Users,DC=example,DC=com`, "O'Brien, Pat", "OU=Users,DC=example,DC=com", "DC=example,DC=com"},
+		{`cn=\#admins \+ ops,ou=Groups,dc=my-corp,dc=local`, "#admins + ops", "ou=Groups,dc=my-corp,dc=local", "dc=my-corp,dc=local"},
+		{`cn=Pat+sn=Smith,dc=example,dc=com`, "Pat", "dc=example,dc=com", "dc=example,dc=com"},
+		{`cn=a\2cb,dc=example,dc=com`, "a,b", "dc=example,dc=com", "dc=example,dc=com"},
+		{`ou=Users,o=example`, "Users", "o=example", "ou=Users,o=example"},
+	}
+	for _, c := range cases {
+		dn, err := parseDN(c.dn)
+		if err != nil {
+			t.Fatalf("%s: %s", c.dn, err)
+		}
+		if dn.Name() != c.name {
+			t.Errorf("%s: expected name %q, got %q", c.dn, c.name, dn.Name())
+		}
+		if parent := dn.Parent().String(); parent != c.parent {
+			t.Errorf("%s: expected parent %q, got %q", c.dn, c.parent, parent)
+		}
+		if domain := dn.Domain().String(); domain != c.domain {
+			t.Errorf("%s: expected domain %q, got %q", c.dn, c.domain, domain)
+		}
+	}
+
+	if _, err := parseDN("not a dn"); err == nil {
+		t.Error("expected an error for an invalid DN")
+	}
+}
+
+func TestDistinguishedNameString(t *testing.T) {
+	dn, err := parseDN(`CN=O'Brien\, Pat+sn=x\;y,DC=example,DC=com`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if s := dn.String(); s != `CN=O'Brien\, Pat+sn=x\;y,DC=example,DC=com` {
+		t.Fatalf("unexpected DN %s", s)
+	}
+}
+
+func TestDistinguishedNameEqual(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"CN=Pat,OU=Users,DC=example,DC=com", "cn=pat,ou=users,dc=EXAMPLE,dc=com", true},
+		{`cn=O'Brien\, Pat,dc=example`, `cn=O'Brien\2C Pat, dc=example`, true},
+		{"cn=Pat+sn=Smith,dc=example", "sn=smith+cn=pat,dc=example", true},
+		{"cn=Pat,dc=example", "cn=Pat,dc=example,dc=com", false},
+		{"cn=Pat+sn=Smith,dc=example", "cn=Pat,dc=example", false},
+		{"cn=Pat,dc=example", "ou=Pat,dc=example", false},
+	}
+	for _, c := range cases {
+		a, _ := parseDN(c.a)
+		b, _ := parseDN(c.b)
+		if a.Equal(b) != c.equal {
+			t.Errorf("expected %s equal to %s to be %t", c.a, c.b, c.equal)
+		}
+	}
+}
+
+func TestChildDN(t *testing.T) {
+	cases := map[string]string{
+		"O'Brien, Pat": `cn=O'Brien\, Pat,ou=Users,dc=example,dc=com`,
+		"#1 + 2":       `cn=\#1 \+ 2,ou=Users,dc=example,dc=com`,
+		" padded ":     `cn=\ padded\ ,ou=Users,dc=example,dc=com`,
+		`a\b="c"<d>`:   `cn=a\\b\=\"c\"\<d\>,ou=Users,dc=example,dc=com`,
+	}
+	for name, expected := range cases {
+		dn := childDN("cn", name, "ou=Users,dc=example,dc=com")
+		if dn != expected {
+			t.Errorf("expected %s, got %s", expected, dn)
+		}
+		parsed, err := parseDN(dn)
+		if err != nil {
+			t.Fatalf("%s: %s", dn, err)
+		}
+		if parsed.Name() != name {
+			t.Errorf("expected %q to round trip, got %q", name, parsed.Name())
+		}
+	}
+}
+
+func TestParseExtendedDN(t *testing.T) {
+	for _, c := range []struct {
+		extended, guid, dn string
+	}{
+		{"<GUID=b6b3c1d4e1f2a3b4c5d6e7f8a9b0c1d2>;<SID=010500000000000515000000dcf4dc3b833d2b46828ba62850040000>;CN=Jane Doe,DC=example,DC=com", "b6b3c1d4e1f2a3b4c5d6e7f8a9b0c1d2", "CN=Jane Doe,DC=example,DC=com"},
+		{"<GUID=b6b3c1d4e1f2a3b4c5d6e7f8a9b0c1d2>;OU=Servers,DC=example,DC=com", "b6b3c1d4e1f2a3b4c5d6e7f8a9b0c1d2", "OU=Servers,DC=example,DC=com"},
+		{`CN=Doe\, Jane,DC=example,DC=com`, "", `CN=Doe\, Jane,DC=example,DC=com`},
+		{`<GUID=b6b3c1d4e1f2a3b4c5d6e7f8a9b0c1d2>;CN=a\3c\3e\3b b,DC=example,DC=com`, "b6b3c1d4e1f2a3b4c5d6e7f8a9b0c1d2", `CN=a\3c\3e\3b b,DC=example,DC=com`},
+		{"", "", ""},
+	} {
+		guid, dn := parseExtendedDN(c.extended)
+		if guid != c.guid || dn != c.dn {
+			t.Errorf("%s: expected %q and %q, got %q and %q", c.extended, c.guid, c.dn, guid, dn)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package ad
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+// AD puts a Windows error code in front of its diagnostic messages, followed
+// by the DSID of the code that failed, e.g. "0000052D: Constraint violation -
+// check the password policy: 0000052D: SvcErr: DSID-031A1248, problem 5003
+// (WILL_NOT_PERFORM), data 0". Failed binds tell the reason in the data part
+// like "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext
+// error, data 52e, v3839".
+var (
+	adErrorCodePattern = regexp.MustCompile(`^([0-9A-Fa-f]{8}):`)
+	adDataPattern      = regexp.MustCompile(`data ([0-9A-Fa-f]+)`)
+	adAttributePattern = regexp.MustCompile(`Att [0-9A-Fa-f]+ \(([^)]+)\)`)
+)
+
+// adError is a failed LDAP operation on an entry, explained using the result
+// code and the extended error of AD.
+type adError struct {
+	err       *ldap.Error
+	dn        string
+	attribute string
+	code      uint32
+	data      string
+	problem   string
+}
+
+func (e *adError) Error() string {
+	return fmt.Sprintf("%s (%s)", e.problem, e.err)
+}
+
+func (e *adError) Unwrap() error {
+	return e.err
+}
+
+// describeADError explains why an LDAP operation on the entry with the given
+// DN failed and what to do about it. attribute names the attribute written,
+// if the operation wrote a single one. Errors without a known explanation
+// are returned as they are.
+func describeADError(err error, dn string, attribute string) error {
+	ldapErr, ok := err.(*ldap.Error)
+	if !ok || ldapErr.Err == nil {
+		return err
+	}
+
+	e := &adError{err: ldapErr, dn: dn, attribute: attribute}
+	diagnostic := ldapErr.Err.Error()
+	if m := adErrorCodePattern.FindStringSubmatch(diagnostic); m != nil {
+		code, _ := strconv.ParseUint(m[1], 16, 32)
+		e.code = uint32(code)
+	}
+	if m := adDataPattern.FindStringSubmatch(diagnostic); m != nil {
+		e.data = strings.ToLower(m[1])
+	}
+	if m := adAttributePattern.FindStringSubmatch(diagnostic); m != nil && e.attribute == "" {
+		e.attribute = m[1]
+	}
+
+	if reason, ok := bindProblems[e.data]; ok && ldapErr.ResultCode == ldap.LDAPResultInvalidCredentials {
+		e.problem = fmt.Sprintf("the bind as %s was refused, %s", e.dn, reason)
+	}
+	if e.problem == "" && adErrorProblems[e.code] != nil {
+		e.problem = adErrorProblems[e.code](e)
+	}
+	if e.problem == "" && resultCodeProblems[ldapErr.ResultCode] != nil {
+		e.problem = resultCodeProblems[ldapErr.ResultCode](e)
+	}
+	if e.problem == "" {
+		return err
+	}
+	return e
+}
+
+// value names the attribute written, or the entry if it is not known.
+func (e *adError) value() string {
+	if e.attribute != "" {
+		return fmt.Sprintf("the value of %s of %s", e.attribute, e.dn)
+	}
+	return fmt.Sprintf("a value of %s", e.dn)
+}
+
+// isPassword reports whether the operation wrote the password.
+func (e *adError) isPassword() bool {
+	return strings.EqualFold(e.attribute, "unicodePwd")
+}
+
+// missing names the entry which does not exist, AD returns the closest
+// existing entry as matched DN.
+func (e *adError) missing() string {
+	if e.err.MatchedDN == "" {
+		return fmt.Sprintf("%s or its parent does not exist", e.dn)
+	}
+	return fmt.Sprintf("%s or its parent does not exist, the closest existing entry is %s", e.dn, e.err.MatchedDN)
+}
+
+// adErrorProblems explain the Windows error codes AD returns in front of the
+// diagnostic message.
+var adErrorProblems = map[uint32]func(e *adError) string{
+	// ERROR_ACCESS_DENIED
+	0x00000005: func(e *adError) string {
+		return fmt.Sprintf("the provider user is not allowed to change %s, delegate the permission to it", e.value())
+	},
+	// ERROR_GEN_FAILURE, AD refuses to write passwords over unencrypted connections
+	0x0000001F: func(e *adError) string {
+		if e.isPassword() {
+			return fmt.Sprintf("the password of %s can only be set over an encrypted connection, use the ldaps or starttls transport", e.dn)
+		}
+		return ""
+	},
+	// ERROR_INVALID_PASSWORD
+	0x00000056: func(e *adError) string {
+		return fmt.Sprintf("the current password given to change the password of %s is wrong", e.dn)
+	},
+	// ERROR_INVALID_PARAMETER
+	0x00000057: func(e *adError) string {
+		return fmt.Sprintf("%s is invalid", e.value())
+	},
+	// ERROR_USER_EXISTS
+	0x00000524: func(e *adError) string {
+		return fmt.Sprintf("the account name (sAMAccountName) of %s is already used by another object of the domain", e.dn)
+	},
+	// ERROR_PASSWORD_RESTRICTION
+	0x0000052D: func(e *adError) string {
+		return fmt.Sprintf("the password of %s does not meet the password policy of the domain, check its length, complexity and history and the minimum password age", e.dn)
+	},
+	// ERROR_MEMBER_NOT_IN_ALIAS
+	0x00000561: func(e *adError) string {
+		return fmt.Sprintf("the member to remove is not a member of the group %s", e.dn)
+	},
+	// ERROR_MEMBER_IN_ALIAS
+	0x00000562: func(e *adError) string {
+		return fmt.Sprintf("the member to add is already a member of the group %s", e.dn)
+	},
+	// ERROR_DS_CANT_ON_NON_LEAF
+	0x00002015: func(e *adError) string {
+		return fmt.Sprintf("%s still contains objects, delete or move them first", e.dn)
+	},
+	// ERROR_DS_CANT_ON_RDN
+	0x00002016: func(e *adError) string {
+		return fmt.Sprintf("the naming attribute of %s can only be changed by renaming it", e.dn)
+	},
+	// ERROR_DS_OBJ_STRING_NAME_EXISTS
+	0x00002071: func(e *adError) string {
+		return fmt.Sprintf("%s already exists, import it or choose another name", e.dn)
+	},
+	// ERROR_DS_NO_PARENT_OBJECT
+	0x00002089: func(e *adError) string {
+		return fmt.Sprintf("the parent of %s does not exist", e.dn)
+	},
+	// ERROR_DS_OBJ_NOT_FOUND
+	0x0000208D: func(e *adError) string {
+		return e.missing()
+	},
+	// ERROR_DS_INSUFF_ACCESS_RIGHTS
+	0x00002098: func(e *adError) string {
+		return fmt.Sprintf("the provider user has insufficient access rights to change %s, delegate the permission to it", e.value())
+	},
+}
+
+// resultCodeProblems explain the LDAP result codes of errors without a known
+// Windows error code.
+var resultCodeProblems = map[uint16]func(e *adError) string{
+	ldap.LDAPResultNoSuchAttribute: func(e *adError) string {
+		return fmt.Sprintf("%s to delete does not exist", e.value())
+	},
+	ldap.LDAPResultConstraintViolation: func(e *adError) string {
+		if e.isPassword() {
+			return fmt.Sprintf("the password of %s was refused, check the password policy of the domain", e.dn)
+		}
+		return fmt.Sprintf("%s violates a constraint of the directory", e.value())
+	},
+	ldap.LDAPResultAttributeOrValueExists: func(e *adError) string {
+		return fmt.Sprintf("%s to add already exists", e.value())
+	},
+	ldap.LDAPResultInvalidAttributeSyntax: func(e *adError) string {
+		return fmt.Sprintf("%s has an invalid syntax", e.value())
+	},
+	ldap.LDAPResultNoSuchObject: func(e *adError) string {
+		return e.missing()
+	},
+	ldap.LDAPResultInvalidDNSyntax: func(e *adError) string {
+		return fmt.Sprintf("%s is not a valid distinguished name", e.dn)
+	},
+	ldap.LDAPResultInvalidCredentials: func(e *adError) string {
+		return fmt.Sprintf("the credentials of %s were refused", e.dn)
+	},
+	ldap.LDAPResultInsufficientAccessRights: func(e *adError) string {
+		return fmt.Sprintf("the provider user has insufficient access rights to change %s, delegate the permission to it", e.value())
+	},
+	ldap.LDAPResultUnwillingToPerform: func(e *adError) string {
+		if e.isPassword() {
+			return fmt.Sprintf("the password of %s can only be set over an encrypted connection, use the ldaps or starttls transport", e.dn)
+		}
+		return ""
+	},
+	ldap.LDAPResultObjectClassViolation: func(e *adError) string {
+		return fmt.Sprintf("%s is missing a mandatory attribute or has one its object class does not allow", e.dn)
+	},
+	ldap.LDAPResultNotAllowedOnNonLeaf: func(e *adError) string {
+		return fmt.Sprintf("%s still contains objects, delete or move them first", e.dn)
+	},
+	ldap.LDAPResultNotAllowedOnRDN: func(e *adError) string {
+		return fmt.Sprintf("the naming attribute of %s can only be changed by renaming it", e.dn)
+	},
+	ldap.LDAPResultEntryAlreadyExists: func(e *adError) string {
+		return fmt.Sprintf("%s already exists, import it or choose another name", e.dn)
+	},
+}
+
+// bindProblems explain the reasons AD gives for refusing a bind.
+var bindProblems = map[string]string{
+	"525": "the user does not exist",
+	"52e": "the password is wrong",
+	"530": "the user is not allowed to log on at this time",
+	"531": "the user is not allowed to log on from this workstation",
+	"532": "the password of the user has expired",
+	"533": "the account of the user is disabled",
+	"701": "the account of the user has expired",
+	"773": "the user has to change the password before logging on",
+	"775": "the account of the user is locked out",
+}
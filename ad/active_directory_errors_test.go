@@ -0,0 +1,142 @@
+package ad
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+func TestDescribeADError(t *testing.T) {
+	dn := "cn=Jane Doe,ou=People,dc=example,dc=com"
+	cases := []struct {
+		code       uint16
+		diagnostic string
+		matchedDN  string
+		attribute  string
+		expected   string
+	}{
+		{
+			ldap.LDAPResultConstraintViolation,
+			"0000052D: Constraint violation - check the password policy: 0000052D: SvcErr: DSID-031A1248, problem 5003 (WILL_NOT_PERFORM), data 0",
+			"", "unicodePwd",
+			"the password of " + dn + " does not meet the password policy of the domain",
+		},
+		{
+			ldap.LDAPResultConstraintViolation,
+			"00000056: AtrErr: DSID-03191083, #1:\n\t0: 00000056: DSID-03191083, problem 1005 (CONSTRAINT_ATT_TYPE), data 0, Att 9005a (unicodePwd)",
+			"", "",
+			"the current password given to change the password of " + dn + " is wrong",
+		},
+		{
+			ldap.LDAPResultUnwillingToPerform,
+			"0000001F: SvcErr: DSID-031A12D2, problem 5003 (WILL_NOT_PERFORM), data 0",
+			"", "unicodePwd",
+			"can only be set over an encrypted connection",
+		},
+		{
+			ldap.LDAPResultEntryAlreadyExists,
+			"00002071: UpdErr: DSID-031B0C20, problem 6005 (ENTRY_EXISTS), data 0",
+			"", "",
+			dn + " already exists, import it or choose another name",
+		},
+		{
+			ldap.LDAPResultEntryAlreadyExists,
+			"00000524: UpdErr: DSID-031A11E0, problem 6005 (ENTRY_EXISTS), data 0",
+			"", "",
+			"the account name (sAMAccountName) of " + dn + " is already used",
+		},
+		{
+			ldap.LDAPResultInsufficientAccessRights,
+			"00002098: SecErr: DSID-03150F94, problem 4003 (INSUFF_ACCESS_RIGHTS), data 0",
+			"", "description",
+			"insufficient access rights to change the value of description of " + dn,
+		},
+		{
+			ldap.LDAPResultNoSuchObject,
+			"0000208D: NameErr: DSID-03100241, problem 2001 (NO_OBJECT), data 0, best match of:\n\t'DC=example,DC=com'",
+			"DC=example,DC=com", "",
+			"or its parent does not exist, the closest existing entry is DC=example,DC=com",
+		},
+		{
+			ldap.LDAPResultAttributeOrValueExists,
+			"00000562: UpdErr: DSID-031A1262, problem 6005 (ENTRY_EXISTS), data 0",
+			"", "member",
+			"the member to add is already a member of the group " + dn,
+		},
+		{
+			ldap.LDAPResultNotAllowedOnNonLeaf,
+			"00002015: SvcErr: DSID-031A1236, problem 5003 (WILL_NOT_PERFORM), data 0",
+			"", "",
+			dn + " still contains objects",
+		},
+		{
+			ldap.LDAPResultNoSuchAttribute,
+			"00002083: AtrErr: DSID-03151904, #1:\n\t0: 00002083: DSID-03151904, problem 1001 (NO_ATTRIBUTE_OR_VAL), data 0, Att 1f (member)",
+			"", "",
+			"the value of member of " + dn + " to delete does not exist",
+		},
+		{
+			ldap.LDAPResultInvalidCredentials,
+			"80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 775, v3839",
+			"", "",
+			"the bind as " + dn + " was refused, the account of the user is locked out",
+		},
+		{
+			// without a diagnostic message the result code is explained
+			ldap.LDAPResultEntryAlreadyExists, "", "", "",
+			dn + " already exists",
+		},
+	}
+	for _, c := range cases {
+		err := describeADError(&ldap.Error{ResultCode: c.code, MatchedDN: c.matchedDN, Err: errors.New(c.diagnostic)}, dn, c.attribute)
+		if !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("expected %q to contain %q", err, c.expected)
+		}
+		// the original error stays available
+		var ldapErr *ldap.Error
+		if !errors.As(err, &ldapErr) || ldapErr.ResultCode != c.code || !strings.Contains(err.Error(), c.diagnostic) {
+			t.Errorf("expected %q to wrap the LDAP error", err)
+		}
+	}
+}
+
+func TestDescribeADError_unknown(t *testing.T) {
+	for _, err := range []error{
+		fmt.Errorf("not an LDAP error"),
+		&ldap.Error{ResultCode: ldap.LDAPResultBusy, Err: errors.New("00002024: SvcErr: DSID-0208040C, problem 5001 (BUSY), data 0")},
+		&ldap.Error{ResultCode: ldap.LDAPResultUnwillingToPerform, Err: errors.New("")},
+	} {
+		if described := describeADError(err, "cn=web01,dc=example,dc=com", "description"); described != err {
+			t.Errorf("expected %q to be returned as it is, got %q", err, described)
+		}
+	}
+}
+
+func TestDescribeADError_resource(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("cn=Operators,dc=example,dc=com", map[string][]string{"objectClass": {"group"}})
+
+	d := resourceGroup().Data(nil)
+	d.Set("name", "Operators")
+	d.Set("parent", "dc=example,dc=com")
+	d.Set("type", "GLOBAL")
+	err := resourceADGroupCreate(d, conn)
+	if err == nil || !strings.Contains(err.Error(), "cn=Operators,dc=example,dc=com already exists, import it or choose another name") {
+		t.Fatalf("expected an explanation of the error, got %v", err)
+	}
+
+	d = resourceGroup().Data(nil)
+	d.Set("name", "Operators")
+	d.Set("parent", "ou=Missing,dc=example,dc=com")
+	d.Set("type", "GLOBAL")
+	err = resourceADGroupCreate(d, conn)
+	if err == nil || !strings.Contains(err.Error(), "cn=Operators,ou=Missing,dc=example,dc=com or its parent does not exist") {
+		t.Fatalf("expected an explanation of the error, got %v", err)
+	}
+}
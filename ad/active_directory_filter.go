@@ -0,0 +1,32 @@
+package ad
+
+import (
+	"fmt"
+	"strings"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+// The filter helpers build search filters in the string representation of
+// RFC 4515. Assertion values are always escaped, so names containing
+// parentheses, asterisks or backslashes match literally.
+
+// filterEqual returns an equality filter (attribute=value).
+func filterEqual(attribute string, value string) string {
+	return fmt.Sprintf("(%s=%s)", attribute, ldap.EscapeFilter(value))
+}
+
+// filterPresent returns a presence filter (attribute=*).
+func filterPresent(attribute string) string {
+	return fmt.Sprintf("(%s=*)", attribute)
+}
+
+// filterAnd returns a filter matching all the given filters.
+func filterAnd(filters ...string) string {
+	return "(&" + strings.Join(filters, "") + ")"
+}
+
+// filterOr returns a filter matching any of the given filters.
+func filterOr(filters ...string) string {
+	return "(|" + strings.Join(filters, "") + ")"
+}
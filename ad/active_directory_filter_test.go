@@ -0,0 +1,35 @@
+package ad
+
+import (
+	"testing"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+func TestFilterEqual(t *testing.T) {
+	cases := map[string]string{
+		"jdoe":                "(sAMAccountName=jdoe)",
+		"*":                   `(sAMAccountName=\2a)`,
+		"a)(objectClass=*":    `(sAMAccountName=a\29\28objectClass=\2a)`,
+		`O'Brien\, Pat (ext)`: `(sAMAccountName=O'Brien\5c, Pat \28ext\29)`,
+	}
+	for value, expected := range cases {
+		filter := filterEqual("sAMAccountName", value)
+		if filter != expected {
+			t.Errorf("expected %s, got %s", expected, filter)
+		}
+		if _, err := ldap.CompileFilter(filter); err != nil {
+			t.Errorf("%s does not compile: %s", filter, err)
+		}
+	}
+}
+
+func TestFilterAnd(t *testing.T) {
+	filter := filterAnd(filterEqual("objectClass", "user"), filterPresent("mail"))
+	if filter != "(&(objectClass=user)(mail=*))" {
+		t.Fatalf("unexpected filter %s", filter)
+	}
+	if _, err := ldap.CompileFilter(filter); err != nil {
+		t.Fatalf("%s does not compile: %s", filter, err)
+	}
+}
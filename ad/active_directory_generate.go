@@ -0,0 +1,405 @@
+package ad
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// generatePageSize is the number of entries requested per page while walking
+// the subtree.
+const generatePageSize = 500
+
+// Generate implements the generate command. It walks the subtree below a base
+// DN and writes the ad_ou, ad_group, ad_user and ad_computer resources found
+// there as HCL, and a script importing them into the state.
+func Generate(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	baseDN := flags.String("base-dn", "", "the DN of the subtree to generate the configuration for")
+	out := flags.String("out", "ad_import.tf", "the file the configuration is written to, - for stdout")
+	imports := flags.String("imports", "ad_import.sh", "the file the import commands are written to, - for stdout")
+	settings := providerSettings{}
+	flags.Var(settings, "provider", "a provider argument as key=value, may be repeated. Arguments not given are taken from the AD_* environment variables like in the provider block")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *baseDN == "" {
+		return fmt.Errorf("-base-dn is required")
+	}
+
+	client, err := settings.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	objects, err := collectADObjects(*baseDN, client)
+	if err != nil {
+		return err
+	}
+
+	if err := writeOutput(*out, func(w io.Writer) error { return writeHCL(w, objects) }); err != nil {
+		return err
+	}
+	return writeOutput(*imports, func(w io.Writer) error { return writeImports(w, objects) })
+}
+
+// providerSettings are the provider arguments given on the command line.
+type providerSettings map[string]string
+
+func (s providerSettings) String() string {
+	return ""
+}
+
+func (s providerSettings) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	s[parts[0]] = parts[1]
+	return nil
+}
+
+// client configures the provider with the settings and returns its client.
+// Lists are given as comma separated values.
+func (s providerSettings) client() (adClient, error) {
+	provider := Provider().(*schema.Provider)
+	raw := make(map[string]interface{})
+	for key, value := range s {
+		argument, ok := provider.Schema[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown provider argument %s", key)
+		}
+		if argument.Type == schema.TypeList {
+			var values []interface{}
+			for _, v := range strings.Split(value, ",") {
+				values = append(values, v)
+			}
+			raw[key] = values
+		} else {
+			raw[key] = value
+		}
+	}
+
+	config := terraform.NewResourceConfigRaw(raw)
+	if _, errs := provider.Validate(config); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid provider configuration: %s", errs[0])
+	}
+	if err := provider.Configure(config); err != nil {
+		return nil, err
+	}
+	return provider.Meta().(adClient), nil
+}
+
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	if err := write(w); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// generatedObject is an object found below the base DN and the resource it
+// becomes.
+type generatedObject struct {
+	resourceType string
+	name         string
+	id           string
+	dn           *distinguishedName
+	entry        *ldap.Entry
+
+	// cannotChangePassword is read from the security descriptor of users
+	cannotChangePassword bool
+}
+
+// address returns the address of the resource in the configuration.
+func (o *generatedObject) address() string {
+	return o.resourceType + "." + o.name
+}
+
+// generatedObjects are the objects found below the base DN, indexed by DN to
+// wire up references between them.
+type generatedObjects struct {
+	list []*generatedObject
+	byDN map[string]*generatedObject
+}
+
+// reference returns an expression for the given DN, referring to the dn
+// attribute of the resource of the object if there is one.
+func (o *generatedObjects) reference(dn string) string {
+	parsed, err := parseDN(dn)
+	if err != nil {
+		return hclString(dn)
+	}
+	if object, ok := o.byDN[dnKey(parsed)]; ok {
+		return object.address() + ".dn"
+	}
+	return hclString(dn)
+}
+
+func dnKey(dn *distinguishedName) string {
+	return strings.ToLower(dn.String())
+}
+
+// collectADObjects returns the OUs, groups, users and computers of the
+// subtree, ordered by resource type and DN. Groups of types the ad_group
+// resource cannot represent are skipped with a warning.
+func collectADObjects(baseDN string, adConn adClient) (*generatedObjects, error) {
+	attributes := []string{"objectClass", "objectGUID", "ou", "cn", "description", "groupType", "member", "sAMAccountName", "givenName", "sn", "userAccountControl", "accountExpires"}
+	for _, a := range userAttributes {
+		attributes = append(attributes, a.attribute)
+	}
+	searchRequest := ldap.NewSearchRequest(
+		baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filterOr(
+			filterEqual("objectClass", "organizationalUnit"),
+			filterEqual("objectClass", "group"),
+			filterEqual("objectClass", "user"),
+		),
+		attributes, nil,
+	)
+	entries, err := searchADEntries(searchRequest, generatePageSize, adConn)
+	if err != nil {
+		return nil, fmt.Errorf("Error while searching %s: %s", baseDN, err)
+	}
+
+	objects := &generatedObjects{byDN: make(map[string]*generatedObject)}
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		resourceType := generatedResourceType(entry.GetAttributeValues("objectClass"))
+		if resourceType == "" {
+			continue
+		}
+		dn, err := parseDN(entry.DN)
+		if err != nil {
+			return nil, err
+		}
+		id, err := formatGUID(entry.GetRawAttributeValue("objectGUID"))
+		if err != nil {
+			return nil, fmt.Errorf("Error while reading the objectGUID of %s: %s", entry.DN, err)
+		}
+		object := &generatedObject{resourceType: resourceType, id: id, dn: dn, entry: entry}
+		switch resourceType {
+		case "ad_group":
+			if _, err := groupTypeName(entry.GetAttributeValue("groupType")); err != nil {
+				log.Printf("[WARN] Skipping the group %s: %s", entry.DN, err)
+				continue
+			}
+		case "ad_user":
+			object.cannotChangePassword, err = getUserCannotChangePassword(entry.DN, adConn)
+			if err != nil {
+				return nil, fmt.Errorf("Error while reading the security descriptor of %s: %s", entry.DN, err)
+			}
+		}
+		objects.list = append(objects.list, object)
+		objects.byDN[dnKey(dn)] = object
+	}
+
+	sort.Slice(objects.list, func(i, j int) bool {
+		a, b := objects.list[i], objects.list[j]
+		if a.resourceType != b.resourceType {
+			return a.resourceType < b.resourceType
+		}
+		return dnKey(a.dn) < dnKey(b.dn)
+	})
+	for _, object := range objects.list {
+		object.name = uniqueResourceName(object.resourceType, object.dn.Name(), names)
+	}
+	return objects, nil
+}
+
+// generatedResourceType returns the resource type of an object with the
+// given object classes, or "" if it is none of the supported ones.
+func generatedResourceType(objectClasses []string) string {
+	resourceType := ""
+	for _, objectClass := range objectClasses {
+		switch strings.ToLower(objectClass) {
+		case "computer":
+			return "ad_computer"
+		case "user":
+			resourceType = "ad_user"
+		case "group":
+			resourceType = "ad_group"
+		case "organizationalunit":
+			resourceType = "ad_ou"
+		}
+	}
+	return resourceType
+}
+
+// uniqueResourceName turns the name of an object into a resource name not
+// used by another resource of the same type.
+func uniqueResourceName(resourceType string, name string, used map[string]bool) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "_"):
+			b.WriteRune('_')
+		}
+	}
+	base := strings.TrimSuffix(b.String(), "_")
+	if base == "" || base[0] >= '0' && base[0] <= '9' {
+		base = "_" + base
+	}
+
+	result := base
+	for i := 2; used[resourceType+"."+result]; i++ {
+		result = fmt.Sprintf("%s_%d", base, i)
+	}
+	used[resourceType+"."+result] = true
+	return result
+}
+
+// hclAttribute is an argument of a generated resource block, its value is
+// an HCL expression.
+type hclAttribute struct {
+	key   string
+	value string
+}
+
+// writeHCL writes a resource block for each object. The values are read like
+// the resources read them, arguments with their default value are left out.
+func writeHCL(w io.Writer, objects *generatedObjects) error {
+	groupSchema := resourceGroup().Schema
+	userSchema := resourceUser().Schema
+	users := false
+	for _, object := range objects.list {
+		users = users || object.resourceType == "ad_user"
+	}
+	if users {
+		fmt.Fprint(w, "variable \"initial_password\" {\n  description = \"The password of users created from this configuration\"\n}\n\n")
+	}
+
+	for _, object := range objects.list {
+		entry := object.entry
+		parent := objects.reference(object.dn.Parent().String())
+		var attributes []hclAttribute
+		var members []string
+
+		switch object.resourceType {
+		case "ad_ou":
+			attributes = []hclAttribute{{"name", hclString(object.dn.Name())}, {"parent", parent}}
+		case "ad_group":
+			attributes = []hclAttribute{{"name", hclString(object.dn.Name())}, {"parent", parent}}
+			// collectADObjects skipped groups of unsupported types
+			groupType, _ := groupTypeName(entry.GetAttributeValue("groupType"))
+			if groupType != groupSchema["type"].Default {
+				attributes = append(attributes, hclAttribute{"type", hclString(groupType)})
+			}
+			for _, member := range entry.GetAttributeValues("member") {
+				_, dn := parseExtendedDN(member)
+				members = append(members, objects.reference(dn))
+			}
+		case "ad_user":
+			attributes = []hclAttribute{
+				{"username", hclString(entry.GetAttributeValue("sAMAccountName"))},
+				{"firstname", hclString(entry.GetAttributeValue("givenName"))},
+				{"lastname", hclString(entry.GetAttributeValue("sn"))},
+				{"parent", parent},
+				{"password", "var.initial_password"},
+			}
+			for _, a := range userAttributes {
+				value := entry.GetAttributeValue(a.attribute)
+				switch {
+				case value == "":
+				case a.key == "manager":
+					_, dn := parseExtendedDN(value)
+					attributes = append(attributes, hclAttribute{a.key, objects.reference(dn)})
+				default:
+					attributes = append(attributes, hclAttribute{a.key, hclString(value)})
+				}
+			}
+			if accountExpires, err := formatAccountExpires(entry.GetAttributeValue("accountExpires")); err == nil && accountExpires != userSchema["account_expires"].Default {
+				attributes = append(attributes, hclAttribute{"account_expires", hclString(accountExpires)})
+			}
+			uac, _ := strconv.ParseUint(entry.GetAttributeValue("userAccountControl"), 10, 32)
+			for _, f := range userAccountControlFlags {
+				if value := f.value(uint32(uac)); value != userSchema[f.key].Default {
+					attributes = append(attributes, hclAttribute{f.key, strconv.FormatBool(value)})
+				}
+			}
+			if object.cannotChangePassword != userSchema["cannot_change_password"].Default {
+				attributes = append(attributes, hclAttribute{"cannot_change_password", strconv.FormatBool(object.cannotChangePassword)})
+			}
+		case "ad_computer":
+			attributes = []hclAttribute{{"name", hclString(object.dn.Name())}, {"parent", parent}}
+		}
+		if description := entry.GetAttributeValue("description"); description != "" {
+			attributes = append(attributes, hclAttribute{"description", hclString(description)})
+		}
+
+		fmt.Fprintf(w, "resource %q %q {\n", object.resourceType, object.name)
+		width := 0
+		for _, attribute := range attributes {
+			if len(attribute.key) > width {
+				width = len(attribute.key)
+			}
+		}
+		for _, attribute := range attributes {
+			fmt.Fprintf(w, "  %-*s = %s\n", width, attribute.key, attribute.value)
+		}
+		if len(members) > 0 {
+			sort.Strings(members)
+			fmt.Fprint(w, "\n  members = [\n")
+			for _, member := range members {
+				fmt.Fprintf(w, "    %s,\n", member)
+			}
+			fmt.Fprint(w, "  ]\n")
+		}
+		if object.resourceType == "ad_user" {
+			fmt.Fprint(w, "\n  lifecycle {\n    ignore_changes = [password]\n  }\n")
+		}
+		if _, err := fmt.Fprint(w, "}\n\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeImports(w io.Writer, objects *generatedObjects) error {
+	fmt.Fprint(w, "#!/bin/sh\nset -e\n\n")
+	for _, object := range objects.list {
+		if _, err := fmt.Fprintf(w, "terraform import %s %s\n", object.address(), object.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hclString returns a quoted HCL string literal of the value.
+func hclString(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+		"${", "$${",
+		"%{", "%%{",
+	)
+	return `"` + replacer.Replace(value) + `"`
+}
@@ -0,0 +1,237 @@
+package ad
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcl/hclsyntax"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestGenerateHCL(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=Outside,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	directory.add("cn=Outsider,ou=Outside,dc=example,dc=com", map[string][]string{"objectClass": {"top", "person", "user"}, "sAMAccountName": {"outsider"}})
+	ouID := directory.add("ou=Web Servers,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}, "description": {"the ${tier} tier"}})
+	computerID := directory.add("cn=web01,ou=Web Servers,dc=example,dc=com", map[string][]string{"objectClass": {"top", "person", "user", "computer"}, "sAMAccountName": {"web01$"}})
+	userID := directory.add("cn=Jane Doe,ou=Web Servers,dc=example,dc=com", map[string][]string{
+		"objectClass":    {"top", "person", "user"},
+		"sAMAccountName": {"jdoe"},
+		"givenName":      {"Jane"},
+		"sn":             {"Doe"},
+		"mail":           {"jane.doe@example.com"},
+		"accountExpires": {"134432352000000000"},
+		// disabled, password never expires
+		"userAccountControl": {"66050"},
+	})
+	groupID := directory.add("cn=Web Admins,ou=Web Servers,dc=example,dc=com", map[string][]string{
+		"objectClass": {"top", "group"},
+		"groupType":   {"-2147483644"},
+		"member": {
+			"cn=Jane Doe,ou=Web Servers,dc=example,dc=com",
+			"CN=web01,OU=Web Servers,DC=example,DC=com",
+			"cn=Outsider,ou=Outside,dc=example,dc=com",
+		},
+	})
+
+	objects, err := collectADObjects("ou=Web Servers,dc=example,dc=com", conn)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var hcl bytes.Buffer
+	if err := writeHCL(&hcl, objects); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, expected := range []string{
+		"variable \"initial_password\" {\n",
+		"resource \"ad_ou\" \"web_servers\" {\n  name        = \"Web Servers\"\n  parent      = \"dc=example,dc=com\"\n  description = \"the $${tier} tier\"\n}\n",
+		"resource \"ad_computer\" \"web01\" {\n  name   = \"web01\"\n  parent = ad_ou.web_servers.dn\n}\n",
+		"  username               = \"jdoe\"\n  firstname              = \"Jane\"\n  lastname               = \"Doe\"\n  parent                 = ad_ou.web_servers.dn\n  password               = var.initial_password\n  email                  = \"jane.doe@example.com\"\n  account_expires        = \"2027-01-01T00:00:00Z\"\n  enabled                = false\n  password_never_expires = true\n",
+		"  ignore_changes = [password]\n",
+		"resource \"ad_group\" \"web_admins\" {\n  name   = \"Web Admins\"\n  parent = ad_ou.web_servers.dn\n  type   = \"LOCAL\"\n\n  members = [\n    \"cn=Outsider,ou=Outside,dc=example,dc=com\",\n    ad_computer.web01.dn,\n    ad_user.jane_doe.dn,\n  ]\n}\n",
+	} {
+		if !strings.Contains(hcl.String(), expected) {
+			t.Errorf("expected the configuration to contain\n%s\ngot\n%s", expected, hcl.String())
+		}
+	}
+	if strings.Contains(hcl.String(), "outsider") {
+		t.Errorf("expected only objects of the subtree, got\n%s", hcl.String())
+	}
+
+	var imports bytes.Buffer
+	if err := writeImports(&imports, objects); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "#!/bin/sh\nset -e\n\n" +
+		"terraform import ad_computer.web01 " + computerID + "\n" +
+		"terraform import ad_group.web_admins " + groupID + "\n" +
+		"terraform import ad_ou.web_servers " + ouID + "\n" +
+		"terraform import ad_user.jane_doe " + userID + "\n"
+	if imports.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, imports.String())
+	}
+}
+
+// TestGenerateHCL_roundTrip imports the generated resources and plans the
+// generated configuration against them, which is expected to change nothing.
+func TestGenerateHCL_roundTrip(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=Web Servers,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}, "description": {"the ${tier} tier"}})
+	directory.add("cn=web01,ou=Web Servers,dc=example,dc=com", map[string][]string{"objectClass": {"top", "person", "user", "computer"}, "sAMAccountName": {"web01$"}})
+	directory.add("cn=John Roe,ou=Web Servers,dc=example,dc=com", map[string][]string{
+		"objectClass":        {"top", "person", "user"},
+		"sAMAccountName":     {"jroe"},
+		"givenName":          {"John"},
+		"sn":                 {"Roe"},
+		"userAccountControl": {"512"},
+	})
+	directory.add(`cn=Doe\, Jane,ou=Web Servers,dc=example,dc=com`, map[string][]string{
+		"objectClass":    {"top", "person", "user"},
+		"sAMAccountName": {"jdoe"},
+		"givenName":      {"Jane"},
+		"sn":             {"Doe"},
+		"mail":           {"jane.doe@example.com"},
+		"manager":        {"cn=John Roe,ou=Web Servers,dc=example,dc=com"},
+		"accountExpires": {"134432352000000000"},
+		// disabled, password never expires
+		"userAccountControl": {"66050"},
+	})
+	if err := setUserCannotChangePassword(`cn=Doe\, Jane,ou=Web Servers,dc=example,dc=com`, true, conn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, groupType := range groupTypes {
+		directory.add("cn="+groupType.name+",ou=Web Servers,dc=example,dc=com", map[string][]string{
+			"objectClass":    {"top", "group"},
+			"sAMAccountName": {groupType.name},
+			"groupType":      {groupType.groupType},
+			"member":         {`cn=Doe\, Jane,ou=Web Servers,dc=example,dc=com`, "cn=web01,ou=Web Servers,dc=example,dc=com"},
+		})
+	}
+	directory.add("cn=Administrators,ou=Web Servers,dc=example,dc=com", map[string][]string{
+		"objectClass":    {"top", "group"},
+		"sAMAccountName": {"Administrators"},
+		"groupType":      {"-2147483643"},
+	})
+
+	objects, err := collectADObjects("ou=Web Servers,dc=example,dc=com", conn)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var config bytes.Buffer
+	if err := writeHCL(&config, objects); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if strings.Contains(config.String(), "Administrators") {
+		t.Fatalf("expected the builtin group to be skipped, got\n%s", config.String())
+	}
+	for _, expected := range []string{"cannot_change_password = true", `= "UNIVERSAL_DISTRIBUTION"`} {
+		if !strings.Contains(config.String(), expected) {
+			t.Errorf("expected the configuration to contain %s, got\n%s", expected, config.String())
+		}
+	}
+
+	// the resources refer to each other by the dn read on import
+	states := make(map[string]*terraform.InstanceState)
+	references := map[string]map[string]cty.Value{}
+	for _, object := range objects.list {
+		state := testResourceImport(t, testAccProvider.ResourcesMap[object.resourceType], object.id, conn)
+		states[object.address()] = state
+		if references[object.resourceType] == nil {
+			references[object.resourceType] = make(map[string]cty.Value)
+		}
+		references[object.resourceType][object.name] = cty.ObjectVal(map[string]cty.Value{"dn": cty.StringVal(state.Attributes["dn"])})
+	}
+	ctx := &hcl.EvalContext{Variables: map[string]cty.Value{
+		"var": cty.ObjectVal(map[string]cty.Value{"initial_password": cty.StringVal("Secret123!")}),
+	}}
+	for resourceType, names := range references {
+		ctx.Variables[resourceType] = cty.ObjectVal(names)
+	}
+
+	file, diags := hclsyntax.ParseConfig(config.Bytes(), "ad_import.tf", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Fatalf("err: %s", diags)
+	}
+	planned := 0
+	for _, block := range file.Body.(*hclsyntax.Body).Blocks {
+		if block.Type != "resource" {
+			continue
+		}
+		raw := make(map[string]interface{})
+		for key, attribute := range block.Body.Attributes {
+			value, diags := attribute.Expr.Value(ctx)
+			if diags.HasErrors() {
+				t.Fatalf("err: %s", diags)
+			}
+			raw[key] = testRawValue(value)
+		}
+		address := block.Labels[0] + "." + block.Labels[1]
+		t.Run(address, func(t *testing.T) {
+			testCheckEmptyPlan(t, testAccProvider.ResourcesMap[block.Labels[0]], states[address], raw, conn)
+		})
+		planned++
+	}
+	if planned != len(objects.list) {
+		t.Fatalf("expected a resource block for each of the %d objects, got %d", len(objects.list), planned)
+	}
+}
+
+// testRawValue converts an evaluated HCL expression to a raw configuration
+// value.
+func testRawValue(value cty.Value) interface{} {
+	switch {
+	case value.Type() == cty.Bool:
+		return value.True()
+	case value.Type().IsTupleType() || value.Type().IsListType():
+		var list []interface{}
+		for it := value.ElementIterator(); it.Next(); {
+			_, element := it.Element()
+			list = append(list, testRawValue(element))
+		}
+		return list
+	default:
+		return value.AsString()
+	}
+}
+
+func TestUniqueResourceName(t *testing.T) {
+	used := make(map[string]bool)
+	for _, c := range []struct {
+		resourceType string
+		name         string
+		expected     string
+	}{
+		{"ad_user", "Jane Doe", "jane_doe"},
+		{"ad_user", "jane.doe", "jane_doe_2"},
+		{"ad_group", "Jane Doe", "jane_doe"},
+		{"ad_ou", "2nd Floor", "_2nd_floor"},
+		{"ad_ou", "--", "_"},
+	} {
+		if name := uniqueResourceName(c.resourceType, c.name, used); name != c.expected {
+			t.Errorf("expected %s for %q, got %s", c.expected, c.name, name)
+		}
+	}
+}
+
+func TestProviderSettings(t *testing.T) {
+	settings := providerSettings{}
+	if err := settings.Set("no_such_argument=1"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := settings.client(); err == nil || !strings.Contains(err.Error(), "no_such_argument") {
+		t.Fatalf("expected an error for an unknown argument, got %v", err)
+	}
+	if err := settings.Set("missing-value"); err == nil {
+		t.Fatal("expected an error for a setting without a value")
+	}
+}
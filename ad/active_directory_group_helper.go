@@ -1,51 +1,115 @@
 package ad
 
-import ldap "gopkg.in/ldap.v3"
+import (
+	"fmt"
+	"strings"
 
-func addGroupToAD(groupName string, dnName string, typeOfGroup string, adConn *ldap.Conn, desc string) error {
+	ldap "gopkg.in/ldap.v3"
+)
+
+// groupTypes map the type of groups to their groupType, the scope of the
+// group combined with the security flag 0x80000000 for security groups.
+// Builtin groups and app basic and query groups have other groupTypes.
+var groupTypes = []struct {
+	name      string
+	groupType string
+}{
+	{"GLOBAL", "-2147483646"},
+	{"LOCAL", "-2147483644"},
+	{"UNIVERSAL", "-2147483640"},
+	{"GLOBAL_DISTRIBUTION", "2"},
+	{"LOCAL_DISTRIBUTION", "4"},
+	{"UNIVERSAL_DISTRIBUTION", "8"},
+}
+
+// groupTypeNames returns the supported types of groups.
+func groupTypeNames() []string {
+	names := make([]string, 0, len(groupTypes))
+	for _, t := range groupTypes {
+		names = append(names, t.name)
+	}
+	return names
+}
+
+// groupTypeValue returns the groupType of the given type of group, the type
+// is case-insensitive.
+func groupTypeValue(name string) (string, error) {
+	for _, t := range groupTypes {
+		if strings.EqualFold(t.name, name) {
+			return t.groupType, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported group type %q", name)
+}
+
+// groupTypeName returns the type of a group with the given groupType.
+func groupTypeName(groupType string) (string, error) {
+	for _, t := range groupTypes {
+		if t.groupType == groupType {
+			return t.name, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported groupType %s, only global, domain local and universal security and distribution groups are supported", groupType)
+}
+
+// upgradeGroupType migrates the type of groups in schema version 1. Earlier
+// versions accepted any type and created global groups for all of them but
+// LOCAL, so types other than the supported ones are migrated to GLOBAL.
+func upgradeGroupType(rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	typeOfGroup, _ := rawState["type"].(string)
+	for _, name := range groupTypeNames() {
+		if name == typeOfGroup {
+			return rawState, nil
+		}
+	}
+	rawState["type"] = "GLOBAL"
+	return rawState, nil
+}
+
+func addGroupToAD(groupName string, dnName string, typeOfGroup string, adConn adClient, desc string) error {
+	groupType, err := groupTypeValue(typeOfGroup)
+	if err != nil {
+		return err
+	}
 	addRequest := ldap.NewAddRequest(dnName, nil)
 	addRequest.Attribute("objectClass", []string{"group"})
 	addRequest.Attribute("sAMAccountName", []string{groupName})
 	if desc != "" {
 		addRequest.Attribute("description", []string{desc})
 	}
-	if typeOfGroup == "LOCAL" {
-		addRequest.Attribute("groupType", []string{"-2147483644"})
-	} else {
-		addRequest.Attribute("groupType", []string{"-2147483646"})
-	}
-	err := adConn.Add(addRequest)
+	addRequest.Attribute("groupType", []string{groupType})
+	err = adConn.Add(addRequest)
 	if err != nil {
-		return err
+		return describeADError(err, dnName, "")
 	}
 	return nil
 }
 
-func deleteGroupFromAD(groupDN string, adConn *ldap.Conn) error {
+func deleteGroupFromAD(groupDN string, adConn adClient) error {
 	delRequest := ldap.NewDelRequest(groupDN, nil)
 	err := adConn.Del(delRequest)
 	if err != nil {
-		return err
+		return describeADError(err, groupDN, "")
 	}
 	return nil
 }
 
-func addMemberToGroup(groupDN string, memberDN string, adConn *ldap.Conn) error {
+func addMemberToGroup(groupDN string, memberDN string, adConn adClient) error {
 	modifyRequest := ldap.NewModifyRequest(groupDN, nil)
 	modifyRequest.Add("member", []string{memberDN})
 	err := adConn.Modify(modifyRequest)
 	if err != nil {
-		return err
+		return describeADError(err, groupDN, "member")
 	}
 	return nil
 }
 
-func removeMemberFromGroup(groupDN string, memberDN string, adConn *ldap.Conn) error {
+func removeMemberFromGroup(groupDN string, memberDN string, adConn adClient) error {
 	modifyRequest := ldap.NewModifyRequest(groupDN, nil)
 	modifyRequest.Delete("member", []string{memberDN})
 	err := adConn.Modify(modifyRequest)
 	if err != nil {
-		return err
+		return describeADError(err, groupDN, "member")
 	}
 	return nil
 }
@@ -0,0 +1,332 @@
+package ad
+
+import (
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	ldap "gopkg.in/ldap.v3"
+
+	"gopkg.in/jcmturner/gokrb5.v7/asn1tools"
+	"gopkg.in/jcmturner/gokrb5.v7/client"
+	"gopkg.in/jcmturner/gokrb5.v7/config"
+	"gopkg.in/jcmturner/gokrb5.v7/credentials"
+	"gopkg.in/jcmturner/gokrb5.v7/crypto"
+	"gopkg.in/jcmturner/gokrb5.v7/gssapi"
+	"gopkg.in/jcmturner/gokrb5.v7/iana/chksumtype"
+	"gopkg.in/jcmturner/gokrb5.v7/iana/flags"
+	"gopkg.in/jcmturner/gokrb5.v7/iana/keyusage"
+	"gopkg.in/jcmturner/gokrb5.v7/keytab"
+	"gopkg.in/jcmturner/gokrb5.v7/messages"
+	"gopkg.in/jcmturner/gokrb5.v7/spnego"
+	"gopkg.in/jcmturner/gokrb5.v7/types"
+)
+
+// gssapiClient is the initiator side of a GSS-API security context for a
+// single connection.
+type gssapiClient interface {
+	// InitSecContext returns the initial context token for the service principal.
+	InitSecContext(spn string) ([]byte, error)
+	// AcceptReply verifies the reply token of the service.
+	AcceptReply(token []byte) error
+	// Wrap protects a message sent to the service.
+	Wrap(payload []byte) ([]byte, error)
+	// Unwrap verifies a message received from the service and returns its payload.
+	Unwrap(token []byte) ([]byte, error)
+}
+
+// gssapiBinder runs a SASL GSSAPI bind as described in RFC 4752.
+type gssapiBinder struct {
+	// newClient returns the security context of a new connection.
+	newClient func() (gssapiClient, error)
+	// spn overrides the service principal, which defaults to ldap/<server>.
+	spn string
+}
+
+func (b *gssapiBinder) bind(session *bindSession, server string) (net.Conn, error) {
+	spn := b.spn
+	if spn == "" {
+		host := server
+		if h, _, err := net.SplitHostPort(server); err == nil {
+			host = h
+		}
+		spn = "ldap/" + host
+	}
+	log.Printf("[DEBUG] Binding to %s using Kerberos with service principal %s", server, spn)
+
+	gss, err := b.newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := gss.InitSecContext(spn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get a Kerberos service ticket for %s: %s", spn, err)
+	}
+
+	code, serverCredentials, err := session.saslBind("GSSAPI", token)
+	if err != nil {
+		return nil, err
+	}
+	if code != ldap.LDAPResultSaslBindInProgress {
+		return nil, fmt.Errorf("the server completed the GSSAPI bind without negotiating a security layer")
+	}
+	if len(serverCredentials) > 0 {
+		if err := gss.AcceptReply(serverCredentials); err != nil {
+			return nil, fmt.Errorf("unable to verify the Kerberos reply of %s: %s", server, err)
+		}
+		code, serverCredentials, err = session.saslBind("GSSAPI", []byte{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if code != ldap.LDAPResultSaslBindInProgress {
+		return nil, fmt.Errorf("the server completed the GSSAPI bind without negotiating a security layer")
+	}
+
+	offer, err := gss.Unwrap(serverCredentials)
+	if err != nil || len(offer) != 4 {
+		return nil, fmt.Errorf("invalid GSSAPI security layer offer from %s: %v", server, err)
+	}
+	layers := offer[0]
+	serverMaxBuffer := int(binary.BigEndian.Uint32(offer) & saslMaxBuffer)
+
+	var answer [4]byte
+	switch {
+	case session.isTLS && layers&saslSecurityNone != 0:
+		answer[0] = saslSecurityNone
+	case layers&saslSecurityIntegrity != 0:
+		// the layer in the first byte, our maximum buffer size in the others
+		binary.BigEndian.PutUint32(answer[:], saslMaxBuffer)
+		answer[0] = saslSecurityIntegrity
+	case layers&saslSecurityNone != 0:
+		answer[0] = saslSecurityNone
+	default:
+		return nil, fmt.Errorf("the server only offers GSSAPI confidentiality, which is not supported, use the ldaps or starttls transport instead")
+	}
+
+	reply, err := gss.Wrap(answer[:])
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := session.saslBind("GSSAPI", reply); err != nil {
+		return nil, err
+	}
+
+	if answer[0] == saslSecurityNone {
+		return session.conn, nil
+	}
+	log.Printf("[DEBUG] Signing all messages sent to %s", server)
+	conn := &saslSecurityLayerConn{
+		Conn:   session.conn,
+		wrap:   gss.Wrap,
+		unwrap: gss.Unwrap,
+	}
+	if serverMaxBuffer > 64 {
+		// leave room for the token header and checksum
+		conn.maxBuffer = serverMaxBuffer - 64
+	}
+	return conn, nil
+}
+
+// KerberosConfig holds the settings of the Kerberos bind.
+type KerberosConfig struct {
+	Realm    string
+	Krb5Conf string
+	Keytab   string
+	CCache   string
+	SPN      string
+}
+
+// kerberosClient returns a logged in Kerberos client for the user, using
+// either a keytab, a credential cache or the password.
+func (c *Config) kerberosClient() (*client.Client, error) {
+	realm := c.Kerberos.Realm
+	if realm == "" {
+		realm = strings.ToUpper(c.Domain)
+	}
+
+	krb5conf, err := loadKrb5Config(c.Kerberos.Krb5Conf, realm)
+	if err != nil {
+		return nil, err
+	}
+
+	var cl *client.Client
+	switch {
+	case c.Kerberos.CCache != "":
+		ccache, err := credentials.LoadCCache(c.Kerberos.CCache)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the Kerberos credential cache %s: %s", c.Kerberos.CCache, err)
+		}
+		cl, err = client.NewClientFromCCache(ccache, krb5conf, client.DisablePAFXFAST(true))
+		if err != nil {
+			return nil, fmt.Errorf("unable to use the Kerberos credential cache %s: %s", c.Kerberos.CCache, err)
+		}
+	case c.Kerberos.Keytab != "":
+		kt, err := keytab.Load(c.Kerberos.Keytab)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the Kerberos keytab %s: %s", c.Kerberos.Keytab, err)
+		}
+		cl = client.NewClientWithKeytab(c.Username, realm, kt, krb5conf, client.DisablePAFXFAST(true))
+	default:
+		cl = client.NewClientWithPassword(c.Username, realm, c.Password, krb5conf, client.DisablePAFXFAST(true))
+	}
+
+	if err := cl.Login(); err != nil {
+		return nil, fmt.Errorf("Kerberos login of %s@%s failed: %s", c.Username, realm, err)
+	}
+	return cl, nil
+}
+
+// loadKrb5Config loads the given krb5.conf, the system one or, if there is
+// none, a configuration locating the KDCs of the realm via DNS.
+func loadKrb5Config(path string, realm string) (*config.Config, error) {
+	if path == "" {
+		path = os.Getenv("KRB5_CONFIG")
+	}
+	if path == "" {
+		if _, err := os.Stat("/etc/krb5.conf"); err == nil {
+			path = "/etc/krb5.conf"
+		}
+	}
+
+	if path == "" {
+		krb5conf := config.NewConfig()
+		krb5conf.LibDefaults.DefaultRealm = realm
+		krb5conf.LibDefaults.DNSLookupKDC = true
+		return krb5conf, nil
+	}
+
+	krb5conf, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the Kerberos configuration %s: %s", path, err)
+	}
+	if krb5conf.LibDefaults.DefaultRealm == "" {
+		krb5conf.LibDefaults.DefaultRealm = realm
+	}
+	return krb5conf, nil
+}
+
+// krb5Context is the gssapiClient of the Kerberos V5 mechanism (RFC 4121).
+// It only supports integrity protection of wrapped messages.
+type krb5Context struct {
+	client         *client.Client
+	sessionKey     types.EncryptionKey
+	key            types.EncryptionKey
+	acceptorSubkey bool
+	sequence       uint64
+}
+
+func newKrb5Context(cl *client.Client) *krb5Context {
+	return &krb5Context{client: cl}
+}
+
+func (k *krb5Context) InitSecContext(spn string) ([]byte, error) {
+	ticket, sessionKey, err := k.client.GetServiceTicket(spn)
+	if err != nil {
+		return nil, err
+	}
+
+	authenticator, err := types.NewAuthenticator(k.client.Credentials.Domain(), k.client.Credentials.CName())
+	if err != nil {
+		return nil, err
+	}
+	// RFC 4121 section 4.1.1: empty channel bindings followed by the context flags
+	checksum := make([]byte, 24)
+	binary.LittleEndian.PutUint32(checksum[:4], 16)
+	binary.LittleEndian.PutUint32(checksum[20:], gssapi.ContextFlagMutual|gssapi.ContextFlagInteg)
+	authenticator.Cksum = types.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum:  checksum,
+	}
+
+	apReq, err := messages.NewAPReq(ticket, sessionKey, authenticator)
+	if err != nil {
+		return nil, err
+	}
+	types.SetFlag(&apReq.APOptions, flags.APOptionMutualRequired)
+
+	apReqBytes, err := apReq.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	oid, err := asn1.Marshal(asn1.ObjectIdentifier(gssapi.OID(gssapi.OIDKRB5)))
+	if err != nil {
+		return nil, err
+	}
+
+	k.sessionKey = sessionKey
+	k.key = sessionKey
+	k.sequence = uint64(authenticator.SeqNumber)
+
+	// RFC 2743 section 3.1: mechanism OID, token id of an AP-REQ and the AP-REQ
+	token := append(oid, 0x01, 0x00)
+	token = append(token, apReqBytes...)
+	return asn1tools.AddASNAppTag(token, 0), nil
+}
+
+func (k *krb5Context) AcceptReply(token []byte) error {
+	var reply spnego.KRB5Token
+	if err := reply.Unmarshal(token); err != nil {
+		return err
+	}
+	if reply.IsKRBError() {
+		return reply.KRBError
+	}
+	if !reply.IsAPRep() {
+		return fmt.Errorf("expected an AP-REP token")
+	}
+
+	decrypted, err := crypto.DecryptEncPart(reply.APRep.EncPart, k.sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return err
+	}
+	var part messages.EncAPRepPart
+	if err := part.Unmarshal(decrypted); err != nil {
+		return err
+	}
+	if part.Subkey.KeyType != 0 {
+		k.key = part.Subkey
+		k.acceptorSubkey = true
+	}
+	return nil
+}
+
+func (k *krb5Context) Wrap(payload []byte) ([]byte, error) {
+	etype, err := crypto.GetEtype(k.key.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	token := gssapi.WrapToken{
+		EC:        uint16(etype.GetHMACBitLength() / 8),
+		SndSeqNum: k.sequence,
+		Payload:   payload,
+	}
+	if k.acceptorSubkey {
+		token.Flags = 0x04
+	}
+	if err := token.SetCheckSum(k.key, keyusage.GSSAPI_INITIATOR_SEAL); err != nil {
+		return nil, err
+	}
+	k.sequence++
+	return token.Marshal()
+}
+
+func (k *krb5Context) Unwrap(token []byte) ([]byte, error) {
+	var wrapToken gssapi.WrapToken
+	if err := wrapToken.Unmarshal(token, true); err != nil {
+		return nil, err
+	}
+	if wrapToken.Flags&0x02 != 0 {
+		return nil, fmt.Errorf("sealed messages are not supported")
+	}
+	if _, err := wrapToken.Verify(k.key, keyusage.GSSAPI_ACCEPTOR_SEAL); err != nil {
+		return nil, err
+	}
+	return wrapToken.Payload, nil
+}
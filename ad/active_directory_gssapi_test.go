@@ -0,0 +1,222 @@
+package ad
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	ldap "gopkg.in/ldap.v3"
+
+	"gopkg.in/jcmturner/gokrb5.v7/gssapi"
+	"gopkg.in/jcmturner/gokrb5.v7/iana/etypeID"
+	"gopkg.in/jcmturner/gokrb5.v7/iana/keyusage"
+	"gopkg.in/jcmturner/gokrb5.v7/types"
+)
+
+// testGSSAPIContext stands in for a security context established with a KDC.
+// Its tokens are plain text and wrapped messages carry a CRC32 instead of a
+// keyed checksum.
+type testGSSAPIContext struct {
+	acceptor bool
+}
+
+func (c *testGSSAPIContext) InitSecContext(spn string) ([]byte, error) {
+	return []byte("AP-REQ " + spn), nil
+}
+
+func (c *testGSSAPIContext) AcceptReply(token []byte) error {
+	if string(token) != "AP-REP" {
+		return fmt.Errorf("unexpected reply %q", token)
+	}
+	return nil
+}
+
+func (c *testGSSAPIContext) Wrap(payload []byte) ([]byte, error) {
+	direction := byte('I')
+	if c.acceptor {
+		direction = 'A'
+	}
+	token := append([]byte{direction}, payload...)
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, crc32.ChecksumIEEE(token))
+	return append(token, checksum...), nil
+}
+
+func (c *testGSSAPIContext) Unwrap(token []byte) ([]byte, error) {
+	expected := byte('A')
+	if c.acceptor {
+		expected = 'I'
+	}
+	if len(token) < 5 || token[0] != expected {
+		return nil, fmt.Errorf("unexpected token %q", token)
+	}
+	payload, checksum := token[:len(token)-4], token[len(token)-4:]
+	if binary.BigEndian.Uint32(checksum) != crc32.ChecksumIEEE(payload) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	return payload[1:], nil
+}
+
+// testGSSAPIServer returns a SASL handler accepting GSSAPI binds for the SPN
+// and offering the given security layers.
+func testGSSAPIServer(t *testing.T, spn string, layers byte, chosen *byte) func(*testSASLState, string, []byte) (uint16, []byte) {
+	acceptor := &testGSSAPIContext{acceptor: true}
+	return func(state *testSASLState, mechanism string, credentials []byte) (uint16, []byte) {
+		if mechanism != "GSSAPI" {
+			return ldap.LDAPResultAuthMethodNotSupported, nil
+		}
+		switch state.Step {
+		case 0:
+			if string(credentials) != "AP-REQ "+spn {
+				t.Errorf("unexpected initial token %q", credentials)
+				return ldap.LDAPResultInvalidCredentials, nil
+			}
+			return ldap.LDAPResultSaslBindInProgress, []byte("AP-REP")
+		case 1:
+			offer, _ := acceptor.Wrap([]byte{layers, 0x00, 0x10, 0x00})
+			return ldap.LDAPResultSaslBindInProgress, offer
+		case 2:
+			answer, err := acceptor.Unwrap(credentials)
+			if err != nil || len(answer) != 4 {
+				t.Errorf("invalid security layer answer: %v", err)
+				return ldap.LDAPResultInvalidCredentials, nil
+			}
+			*chosen = answer[0]
+			if answer[0] == saslSecurityIntegrity {
+				state.Wrap = acceptor.Wrap
+				state.Unwrap = acceptor.Unwrap
+			}
+			return ldap.LDAPResultSuccess, nil
+		}
+		return ldap.LDAPResultProtocolError, nil
+	}
+}
+
+func testGSSAPIConnector(transport string, tlsConfig *tls.Config) *connector {
+	return &connector{
+		transport: transport,
+		ldapPort:  389,
+		ldapsPort: 636,
+		tlsConfig: tlsConfig,
+		binder: &gssapiBinder{
+			spn: "ldap/dc1.example.com",
+			newClient: func() (gssapiClient, error) {
+				return &testGSSAPIContext{}, nil
+			},
+		},
+	}
+}
+
+func TestGSSAPIBind_integrity(t *testing.T) {
+	var chosen byte
+	server := newTestLDAPServer(t)
+	defer server.Close()
+	server.SASL = testGSSAPIServer(t, "ldap/dc1.example.com", saslSecurityNone|saslSecurityIntegrity, &chosen)
+
+	conn, err := clientConnect([]string{server.Addr()}, testGSSAPIConnector(transportPlain, nil))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer conn.Close()
+
+	if chosen != saslSecurityIntegrity {
+		t.Fatalf("expected the integrity layer to be chosen, got %d", chosen)
+	}
+
+	// the server refuses compares, getting its answer proves the signed round trip
+	_, err = conn.Compare("cn=test,dc=example,dc=com", "cn", "test")
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+		t.Fatalf("expected the signed request to be answered, got %v", err)
+	}
+}
+
+func TestGSSAPIBind_tls(t *testing.T) {
+	var chosen byte
+	caPEM, cert := generateTestCertificates(t, "dc1.example.com")
+	server := newTestLDAPServer(t)
+	defer server.Close()
+	server.StartTLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.SASL = testGSSAPIServer(t, "ldap/dc1.example.com", saslSecurityNone|saslSecurityIntegrity, &chosen)
+
+	config := Config{CACert: caPEM}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	conn, err := clientConnect([]string{server.Addr()}, testGSSAPIConnector(transportStartTLS, tlsConfig))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer conn.Close()
+
+	if chosen != saslSecurityNone {
+		t.Fatalf("expected no security layer on top of TLS, got %d", chosen)
+	}
+	_, err = conn.Compare("cn=test,dc=example,dc=com", "cn", "test")
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+		t.Fatalf("expected the request to be answered, got %v", err)
+	}
+}
+
+func TestGSSAPIBind_refused(t *testing.T) {
+	server := newTestLDAPServer(t)
+	defer server.Close()
+
+	_, err := clientConnect([]string{server.Addr()}, testGSSAPIConnector(transportPlain, nil))
+	if err == nil {
+		t.Fatal("expected an error if the server does not support GSSAPI")
+	}
+}
+
+func TestKrb5ContextWrap(t *testing.T) {
+	key := types.EncryptionKey{
+		KeyType:  etypeID.AES256_CTS_HMAC_SHA1_96,
+		KeyValue: bytes.Repeat([]byte{0x42}, 32),
+	}
+	context := &krb5Context{key: key, sequence: 7}
+
+	wrapped, err := context.Wrap([]byte("payload"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var initiatorToken gssapi.WrapToken
+	if err := initiatorToken.Unmarshal(wrapped, false); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if ok, err := initiatorToken.Verify(key, keyusage.GSSAPI_INITIATOR_SEAL); !ok {
+		t.Fatalf("invalid initiator token: %s", err)
+	}
+	if initiatorToken.SndSeqNum != 7 || string(initiatorToken.Payload) != "payload" {
+		t.Fatalf("unexpected initiator token %#v", initiatorToken)
+	}
+
+	acceptorToken := gssapi.WrapToken{
+		Flags:     0x01,
+		EC:        12,
+		SndSeqNum: 3,
+		Payload:   []byte("answer"),
+	}
+	if err := acceptorToken.SetCheckSum(key, keyusage.GSSAPI_ACCEPTOR_SEAL); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	token, err := acceptorToken.Marshal()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	payload, err := context.Unwrap(token)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(payload) != "answer" {
+		t.Fatalf("unexpected payload %q", payload)
+	}
+
+	token[len(token)-1] ^= 0xFF
+	if _, err := context.Unwrap(token); err == nil {
+		t.Fatal("expected an error for a tampered token")
+	}
+}
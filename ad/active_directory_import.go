@@ -0,0 +1,111 @@
+package ad
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// importADObject returns the import function of resources of the given
+// object class. Objects are found by their objectGUID, objectSid or DN and,
+// if they are security principals, by their sAMAccountName. The read
+// following the import fills in all the attributes.
+func importADObject(objectClass string, principal bool) schema.StateFunc {
+	return func(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+		importID := d.Id()
+		log.Printf("[DEBUG] Importing the %s %s", objectClass, importID)
+
+		client := timeoutClient(d, meta, schema.TimeoutRead)
+
+		entry, err := findADObject(importID, objectClass, principal, client)
+		if err != nil {
+			log.Printf("[ERROR] Error while importing the %s %s: %s", objectClass, importID, err)
+			return nil, fmt.Errorf("Error while importing the %s %s: %s", objectClass, importID, err)
+		}
+		if entry == nil {
+			return nil, fmt.Errorf("Error while importing the %s %s: no such object", objectClass, importID)
+		}
+
+		id, err := formatGUID(entry.GetRawAttributeValue("objectGUID"))
+		if err != nil {
+			return nil, fmt.Errorf("Error while importing the %s %s: %s", objectClass, importID, err)
+		}
+		_, dn := parseExtendedDN(entry.DN)
+
+		d.SetId(id)
+		d.Set("dn", dn)
+		return []*schema.ResourceData{d}, nil
+	}
+}
+
+// findADObject looks up an object of the given class by the ID given to
+// terraform import. Returns nil if there is no such object.
+func findADObject(importID string, objectClass string, principal bool, adConn adClient) (*ldap.Entry, error) {
+	filter := filterEqual("objectClass", objectClass)
+	attributes := []string{"objectGUID"}
+
+	if _, err := parseGUID(importID); err == nil {
+		return getADEntry(importID, "", filter, attributes, adConn)
+	}
+	if strings.HasPrefix(strings.ToUpper(importID), "S-") {
+		if _, err := parseSID(importID); err != nil {
+			return nil, err
+		}
+		return getADEntry("", "<SID="+importID+">", filter, attributes, adConn)
+	}
+	if strings.Contains(importID, "=") {
+		dn, err := parseDN(importID)
+		if err != nil {
+			return nil, err
+		}
+		return getADEntry("", dn.String(), filter, attributes, adConn)
+	}
+	if !principal {
+		return nil, fmt.Errorf("expected a GUID, SID or DN")
+	}
+
+	accountName := importID
+	if strings.EqualFold(objectClass, "computer") && !strings.HasSuffix(accountName, "$") {
+		// the sAMAccountName of a computer is its name followed by $
+		accountName += "$"
+	}
+	baseDN, err := getDefaultNamingContext(adConn)
+	if err != nil {
+		return nil, err
+	}
+	searchRequest := ldap.NewSearchRequest(
+		baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filterAnd(filter, filterEqual("sAMAccountName", accountName)),
+		attributes, []ldap.Control{&ldapControlServerExtendDN{}},
+	)
+	sr, err := adConn.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Entries) == 0 {
+		return nil, nil
+	}
+	if len(sr.Entries) > 1 {
+		return nil, fmt.Errorf("found ambigious values for sAMAccountName %s", accountName)
+	}
+	return sr.Entries[0], nil
+}
+
+// getDefaultNamingContext returns the DN of the domain the DC belongs to.
+func getDefaultNamingContext(adConn adClient) (string, error) {
+	searchRequest := ldap.NewSearchRequest(
+		"", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		filterPresent("objectClass"), []string{"defaultNamingContext"}, nil,
+	)
+	sr, err := adConn.Search(searchRequest)
+	if err != nil {
+		return "", err
+	}
+	if len(sr.Entries) == 0 || sr.Entries[0].GetAttributeValue("defaultNamingContext") == "" {
+		return "", fmt.Errorf("the root DSE holds no defaultNamingContext")
+	}
+	return sr.Entries[0].GetAttributeValue("defaultNamingContext"), nil
+}
@@ -0,0 +1,93 @@
+package ad
+
+import "testing"
+
+func TestImportADObject(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=Servers,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	id := directory.add(`cn=web\, 01,ou=Servers,dc=example,dc=com`, map[string][]string{
+		"objectClass":    {"top", "user", "computer"},
+		"sAMAccountName": {"web01$"},
+		"description":    {"web server"},
+	})
+	sid := testDomainSID + "-1001"
+
+	for _, importID := range []string{id, sid, `CN=Web\2C 01,OU=Servers,DC=example,DC=com`, "web01", "WEB01$"} {
+		d := resourceComputer().Data(nil)
+		d.SetId(importID)
+
+		imported, err := importADObject("Computer", true)(d, conn)
+		if err != nil {
+			t.Fatalf("%s: %s", importID, err)
+		}
+		if len(imported) != 1 || imported[0].Id() != id {
+			t.Fatalf("%s: expected to import %s, got %v", importID, id, imported)
+		}
+
+		if err := resourceADComputerRead(d, conn); err != nil {
+			t.Fatalf("%s: %s", importID, err)
+		}
+		for key, expected := range map[string]string{
+			"name":        "web, 01",
+			"parent":      "ou=Servers,dc=example,dc=com",
+			"dn":          `cn=web\, 01,ou=Servers,dc=example,dc=com`,
+			"description": "web server",
+			"sid":         sid,
+		} {
+			if value := d.Get(key).(string); value != expected {
+				t.Errorf("%s: expected %s to be %q, got %q", importID, key, expected, value)
+			}
+		}
+	}
+}
+
+func TestImportADObject_notFound(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=Servers,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+
+	for _, c := range []struct {
+		importID    string
+		objectClass string
+		principal   bool
+	}{
+		{"00000000-0000-0000-0000-000000000000", "organizationalunit", false},
+		{testDomainSID + "-4242", "group", true},
+		{"cn=missing,dc=example,dc=com", "group", true},
+		{"missing", "group", true},
+		// the OU exists, but is not a group
+		{"ou=Servers,dc=example,dc=com", "group", true},
+		// OUs have no sAMAccountName
+		{"Servers", "organizationalunit", false},
+		{"S-1-5-x", "group", true},
+	} {
+		d := resourceGroup().Data(nil)
+		d.SetId(c.importID)
+		if _, err := importADObject(c.objectClass, c.principal)(d, conn); err == nil {
+			t.Errorf("expected an error importing %s", c.importID)
+		}
+	}
+}
+
+func TestGetDefaultNamingContext(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	if _, err := getDefaultNamingContext(conn); err == nil {
+		t.Fatal("expected an error without a domain")
+	}
+
+	directory.add("DC=example,DC=com", map[string][]string{"objectClass": {"domain"}})
+	dn, err := getDefaultNamingContext(conn)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if dn != "DC=example,DC=com" {
+		t.Fatalf("unexpected naming context %s", dn)
+	}
+}
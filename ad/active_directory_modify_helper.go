@@ -2,30 +2,36 @@ package ad
 
 import ldap "gopkg.in/ldap.v3"
 
-func updateADEntry(entryDN string, attribute string, newValue string, adConn *ldap.Conn) error {
+// updateADEntry replaces the value of an attribute. An empty value removes
+// the attribute, AD does not store empty strings.
+func updateADEntry(entryDN string, attribute string, newValue string, adConn adClient) error {
 	updateRequest := ldap.NewModifyRequest(entryDN, nil)
-	updateRequest.Replace(attribute, []string{newValue})
+	if newValue == "" {
+		updateRequest.Replace(attribute, []string{})
+	} else {
+		updateRequest.Replace(attribute, []string{newValue})
+	}
 	err := adConn.Modify(updateRequest)
 	if err != nil {
-		return err
+		return describeADError(err, entryDN, attribute)
 	}
 	return nil
 }
 
-func renameADEntry(entryDN string, newName string, adConn *ldap.Conn) error {
+func renameADEntry(entryDN string, newName string, adConn adClient) error {
 	moveRequest := ldap.NewModifyDNRequest(entryDN, newName, true, "")
 	err := adConn.ModifyDN(moveRequest)
 	if err != nil {
-		return err
+		return describeADError(err, entryDN, "")
 	}
 	return nil
 }
 
-func moveADEntry(entryDN string, entryName string, newParentDN string, adConn *ldap.Conn) error {
+func moveADEntry(entryDN string, entryName string, newParentDN string, adConn adClient) error {
 	moveRequest := ldap.NewModifyDNRequest(entryDN, entryName, true, newParentDN)
 	err := adConn.ModifyDN(moveRequest)
 	if err != nil {
-		return err
+		return describeADError(err, entryDN, "")
 	}
 	return nil
 }
@@ -0,0 +1,353 @@
+package ad
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"golang.org/x/crypto/md4"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// authentication choices of the NTLM bind offered by Active Directory
+// besides SASL, see MS-ADTS 5.1.1.1.3
+const (
+	sicilyNegotiate = 10
+	sicilyResponse  = 11
+)
+
+// ntlmBinder binds using NTLMv2. Only the password is used, the NT hash is
+// always derived from it.
+type ntlmBinder struct {
+	domain   string
+	username string
+	password string
+}
+
+func (b *ntlmBinder) bind(session *bindSession, server string) (net.Conn, error) {
+	log.Printf("[DEBUG] Binding to %s using NTLM as %s\\%s", server, b.domain, b.username)
+
+	flags := uint32(ntlmFlags)
+	if !session.isTLS {
+		// domain controllers enforcing LDAP signing refuse unsigned requests
+		// on plain connections, so all messages are signed and sealed
+		flags |= ntlmSessionSecurityFlags
+	}
+
+	challenge, err := session.sicilyBind(sicilyNegotiate, ntlmNegotiateMessage(flags))
+	if err != nil {
+		return nil, err
+	}
+
+	authenticate, security, err := ntlmAuthenticateMessage(challenge, flags, b.domain, b.username, b.password)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NTLM challenge from %s: %s", server, err)
+	}
+
+	if _, err := session.sicilyBind(sicilyResponse, authenticate); err != nil {
+		return nil, err
+	}
+	if security == nil {
+		return session.conn, nil
+	}
+	log.Printf("[DEBUG] Signing and sealing all messages sent to %s", server)
+	return &saslSecurityLayerConn{
+		Conn:   session.conn,
+		wrap:   security.wrap,
+		unwrap: security.unwrap,
+	}, nil
+}
+
+// sicilyBind sends a step of the NTLM bind. The server returns the NTLM
+// challenge in the matched DN of its response.
+func (s *bindSession) sicilyBind(choice ber.Tag, message []byte) ([]byte, error) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationBindRequest, nil, "Bind Request")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 3, "Version"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "NTLM", "User Name"))
+	op.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, choice, string(message), "NTLM"))
+
+	response, err := s.request(op)
+	if err != nil {
+		return nil, err
+	}
+	if code, diagnostic := resultCode(response); code != ldap.LDAPResultSuccess {
+		return nil, ldap.NewError(code, errors.New(diagnostic))
+	}
+	return response.Children[1].Data.Bytes(), nil
+}
+
+// splitDownLevelName splits a DOMAIN\user name. Names without domain belong
+// to the given default domain.
+func splitDownLevelName(name string, defaultDomain string) (string, string) {
+	if i := strings.Index(name, "\\"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return defaultDomain, name
+}
+
+// NTLM negotiate flags, see MS-NLMP 2.2.2.5
+const (
+	ntlmNegotiateUnicode                 = 0x00000001
+	ntlmRequestTarget                    = 0x00000004
+	ntlmNegotiateSign                    = 0x00000010
+	ntlmNegotiateSeal                    = 0x00000020
+	ntlmNegotiateNTLM                    = 0x00000200
+	ntlmNegotiateAlwaysSign              = 0x00008000
+	ntlmNegotiateExtendedSessionSecurity = 0x00080000
+	ntlmNegotiateTargetInfo              = 0x00800000
+	ntlmNegotiate128                     = 0x20000000
+	ntlmNegotiateKeyExchange             = 0x40000000
+	ntlmNegotiate56                      = 0x80000000
+
+	ntlmFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign |
+		ntlmNegotiateExtendedSessionSecurity | ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+
+	// ntlmSessionSecurityFlags are requested on plain connections, the
+	// session security implemented requires all of them
+	ntlmSessionSecurityFlags = ntlmNegotiateSign | ntlmNegotiateSeal | ntlmNegotiateKeyExchange
+)
+
+// the MsvAvTimestamp attribute of the target information, see MS-NLMP 2.2.2.1
+const ntlmAvTimestamp = 7
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmNegotiateMessage returns a NEGOTIATE_MESSAGE without domain and workstation.
+func ntlmNegotiateMessage(flags uint32) []byte {
+	message := make([]byte, 32)
+	copy(message, ntlmSignature)
+	binary.LittleEndian.PutUint32(message[8:], 1)
+	binary.LittleEndian.PutUint32(message[12:], flags)
+	return message
+}
+
+// ntlmAuthenticateMessage answers a CHALLENGE_MESSAGE with an
+// AUTHENTICATE_MESSAGE carrying NTLMv2 responses. If the session security
+// flags were requested, it also returns the session protecting the messages
+// sent after the bind.
+func ntlmAuthenticateMessage(challenge []byte, requested uint32, domain, username, password string) ([]byte, *ntlmSession, error) {
+	if len(challenge) < 48 || !bytes.Equal(challenge[:8], ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, nil, fmt.Errorf("not an NTLM challenge message")
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:]) & requested
+	serverChallenge := challenge[24:32]
+	targetInfo, err := ntlmPayload(challenge, 40)
+	if err != nil {
+		return nil, nil, err
+	}
+	sessionSecurity := requested&ntlmSessionSecurityFlags != 0
+	required := uint32(ntlmSessionSecurityFlags | ntlmNegotiateExtendedSessionSecurity | ntlmNegotiate128)
+	if sessionSecurity && flags&required != required {
+		return nil, nil, fmt.Errorf("the server refuses to sign and seal messages with 128 bit keys, use the ldaps or starttls transport instead")
+	}
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, nil, err
+	}
+
+	timestamp := ntlmAvPair(targetInfo, ntlmAvTimestamp)
+	serverTimestamp := timestamp != nil
+	if !serverTimestamp {
+		timestamp = make([]byte, 8)
+		binary.LittleEndian.PutUint64(timestamp, ntlmFiletime(time.Now()))
+	}
+
+	ntResponse, lmResponse, sessionBaseKey := ntlmv2Response(ntlmHash(password), username, domain, serverChallenge, clientChallenge, timestamp, targetInfo)
+	if serverTimestamp {
+		// MS-NLMP 3.1.5.1.2: no LMv2 response if the server sent a timestamp
+		lmResponse = make([]byte, 24)
+	}
+
+	var security *ntlmSession
+	var encryptedSessionKey []byte
+	if sessionSecurity {
+		// the session key is chosen by the client and sent encrypted with the
+		// key exchange key, which is the session base key for NTLMv2
+		sessionKey := make([]byte, 16)
+		if _, err := rand.Read(sessionKey); err != nil {
+			return nil, nil, err
+		}
+		encryptedSessionKey = make([]byte, 16)
+		cipher, _ := rc4.NewCipher(sessionBaseKey)
+		cipher.XORKeyStream(encryptedSessionKey, sessionKey)
+		security = newNTLMSession(sessionKey, true)
+	}
+
+	fields := [][]byte{
+		lmResponse,
+		ntResponse,
+		ntlmUnicode(domain),
+		ntlmUnicode(username),
+		nil, // workstation
+		encryptedSessionKey,
+	}
+
+	message := make([]byte, 64)
+	copy(message, ntlmSignature)
+	binary.LittleEndian.PutUint32(message[8:], 3)
+	binary.LittleEndian.PutUint32(message[60:], flags|ntlmNegotiateUnicode)
+	for i, field := range fields {
+		header := message[12+8*i:]
+		binary.LittleEndian.PutUint16(header, uint16(len(field)))
+		binary.LittleEndian.PutUint16(header[2:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(message)))
+		message = append(message, field...)
+	}
+	return message, security, nil
+}
+
+// ntlmv2Response computes the NTLMv2 and LMv2 responses and the session base
+// key, see MS-NLMP 3.3.2.
+func ntlmv2Response(ntHash []byte, username, domain string, serverChallenge, clientChallenge, timestamp, targetInfo []byte) ([]byte, []byte, []byte) {
+	responseKey := hmacMD5(ntHash, ntlmUnicode(strings.ToUpper(username)+domain))
+
+	var temp bytes.Buffer
+	temp.Write([]byte{0x01, 0x01, 0, 0, 0, 0, 0, 0})
+	temp.Write(timestamp)
+	temp.Write(clientChallenge)
+	temp.Write([]byte{0, 0, 0, 0})
+	temp.Write(targetInfo)
+	temp.Write([]byte{0, 0, 0, 0})
+
+	ntProof := hmacMD5(responseKey, append(append([]byte{}, serverChallenge...), temp.Bytes()...))
+	ntResponse := append(ntProof, temp.Bytes()...)
+
+	lmProof := hmacMD5(responseKey, append(append([]byte{}, serverChallenge...), clientChallenge...))
+	lmResponse := append(lmProof, clientChallenge...)
+	return ntResponse, lmResponse, hmacMD5(responseKey, ntProof)
+}
+
+// ntlmSession signs and seals the messages of a connection after an NTLM
+// bind, using extended session security with key exchange, see MS-NLMP
+// 3.4. Each direction has its own keys, sequence number and RC4 state.
+type ntlmSession struct {
+	sendSigningKey    []byte
+	sendSeal          *rc4.Cipher
+	sendSequence      uint32
+	receiveSigningKey []byte
+	receiveSeal       *rc4.Cipher
+	receiveSequence   uint32
+}
+
+// newNTLMSession derives the keys of the client or the server side from the
+// exported session key, see MS-NLMP 3.4.5.
+func newNTLMSession(sessionKey []byte, client bool) *ntlmSession {
+	send, receive := "client-to-server", "server-to-client"
+	if !client {
+		send, receive = receive, send
+	}
+	sendSeal, _ := rc4.NewCipher(ntlmSubkey(sessionKey, "session key to "+send+" sealing key magic constant"))
+	receiveSeal, _ := rc4.NewCipher(ntlmSubkey(sessionKey, "session key to "+receive+" sealing key magic constant"))
+	return &ntlmSession{
+		sendSigningKey:    ntlmSubkey(sessionKey, "session key to "+send+" signing key magic constant"),
+		sendSeal:          sendSeal,
+		receiveSigningKey: ntlmSubkey(sessionKey, "session key to "+receive+" signing key magic constant"),
+		receiveSeal:       receiveSeal,
+	}
+}
+
+func ntlmSubkey(sessionKey []byte, magic string) []byte {
+	hash := md5.New()
+	hash.Write(sessionKey)
+	hash.Write([]byte(magic + "\x00"))
+	return hash.Sum(nil)
+}
+
+// wrap seals a message, the token is the signature followed by the sealed
+// message.
+func (s *ntlmSession) wrap(message []byte) ([]byte, error) {
+	token := make([]byte, 16+len(message))
+	s.sendSeal.XORKeyStream(token[16:], message)
+	ntlmSign(token[:16], s.sendSigningKey, s.sendSeal, s.sendSequence, message)
+	s.sendSequence++
+	return token, nil
+}
+
+// unwrap unseals a token of the peer and verifies its signature.
+func (s *ntlmSession) unwrap(token []byte) ([]byte, error) {
+	if len(token) < 16 {
+		return nil, fmt.Errorf("NTLM token of %d bytes is too short", len(token))
+	}
+	message := make([]byte, len(token)-16)
+	s.receiveSeal.XORKeyStream(message, token[16:])
+	expected := make([]byte, 16)
+	ntlmSign(expected, s.receiveSigningKey, s.receiveSeal, s.receiveSequence, message)
+	s.receiveSequence++
+	if !hmac.Equal(expected, token[:16]) {
+		return nil, fmt.Errorf("invalid NTLM signature")
+	}
+	return message, nil
+}
+
+// ntlmSign writes the signature of a message into the 16 bytes given, see
+// MS-NLMP 3.4.4.2. The checksum is encrypted with the sealing RC4 state.
+func ntlmSign(signature []byte, signingKey []byte, seal *rc4.Cipher, sequence uint32, message []byte) {
+	binary.LittleEndian.PutUint32(signature, 1)
+	binary.LittleEndian.PutUint32(signature[12:], sequence)
+	checksum := hmacMD5(signingKey, append(append([]byte{}, signature[12:]...), message...))
+	seal.XORKeyStream(signature[4:12], checksum[:8])
+}
+
+// ntlmPayload returns the payload referenced by the field header at offset.
+func ntlmPayload(message []byte, offset int) ([]byte, error) {
+	length := int(binary.LittleEndian.Uint16(message[offset:]))
+	start := int(binary.LittleEndian.Uint32(message[offset+4:]))
+	if start+length > len(message) {
+		return nil, fmt.Errorf("field exceeds the message")
+	}
+	return message[start : start+length], nil
+}
+
+// ntlmAvPair returns the value of an attribute of the target information.
+func ntlmAvPair(targetInfo []byte, id uint16) []byte {
+	for len(targetInfo) >= 4 {
+		avID := binary.LittleEndian.Uint16(targetInfo)
+		length := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if avID == 0 || 4+length > len(targetInfo) {
+			return nil
+		}
+		if avID == id {
+			return targetInfo[4 : 4+length]
+		}
+		targetInfo = targetInfo[4+length:]
+	}
+	return nil
+}
+
+func ntlmHash(password string) []byte {
+	hash := md4.New()
+	hash.Write(ntlmUnicode(password))
+	return hash.Sum(nil)
+}
+
+func ntlmUnicode(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	result := make([]byte, 2*len(encoded))
+	for i, c := range encoded {
+		binary.LittleEndian.PutUint16(result[2*i:], c)
+	}
+	return result
+}
+
+// ntlmFiletime returns the time in 100 nanosecond intervals since 1601.
+func ntlmFiletime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+func hmacMD5(key []byte, data []byte) []byte {
+	mac := hmac.New(md5.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
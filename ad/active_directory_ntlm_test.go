@@ -0,0 +1,219 @@
+package ad
+
+import (
+	"bytes"
+	"crypto/rc4"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// testNTLMAvPairs encodes the given attribute ids and values followed by MsvAvEOL.
+func testNTLMAvPairs(pairs ...interface{}) []byte {
+	var result []byte
+	for i := 0; i < len(pairs); i += 2 {
+		value := pairs[i+1].([]byte)
+		header := make([]byte, 4)
+		binary.LittleEndian.PutUint16(header, uint16(pairs[i].(int)))
+		binary.LittleEndian.PutUint16(header[2:], uint16(len(value)))
+		result = append(append(result, header...), value...)
+	}
+	return append(result, 0, 0, 0, 0)
+}
+
+func TestNTLMv2Response(t *testing.T) {
+	// test vectors of MS-NLMP section 4.2.4
+	ntHash := ntlmHash("Password")
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	clientChallenge := bytes.Repeat([]byte{0xaa}, 8)
+	targetInfo := testNTLMAvPairs(2, ntlmUnicode("Domain"), 1, ntlmUnicode("Server"))
+
+	ntResponse, lmResponse, sessionBaseKey := ntlmv2Response(ntHash, "User", "Domain", serverChallenge, clientChallenge, make([]byte, 8), targetInfo)
+
+	if proof := hex.EncodeToString(ntResponse[:16]); proof != "68cd0ab851e51c96aabc927bebef6a1c" {
+		t.Fatalf("unexpected NTProofStr %s", proof)
+	}
+	if lm := hex.EncodeToString(lmResponse); lm != "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa" {
+		t.Fatalf("unexpected LMv2 response %s", lm)
+	}
+	if key := hex.EncodeToString(sessionBaseKey); key != "8de40ccadbc14a82f15cb0ad0de95ca3" {
+		t.Fatalf("unexpected session base key %s", key)
+	}
+}
+
+func TestNTLMSession(t *testing.T) {
+	// test vectors of MS-NLMP section 4.2.4.4
+	sessionKey := bytes.Repeat([]byte{0x55}, 16)
+	client := newNTLMSession(sessionKey, true)
+	if key := hex.EncodeToString(client.sendSigningKey); key != "4788dc861b4782f35d43fd98fe1a2d39" {
+		t.Fatalf("unexpected signing key %s", key)
+	}
+
+	token, err := client.wrap(ntlmUnicode("Plaintext"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if hex.EncodeToString(token) != "010000007fb38ec5c55d497600000000"+"54e50165bf1936dc996020c1811b0f06fb5f" {
+		t.Fatalf("unexpected token %x", token)
+	}
+
+	server := newNTLMSession(sessionKey, false)
+	message, err := server.unwrap(token)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(message, ntlmUnicode("Plaintext")) {
+		t.Fatalf("unexpected message %x", message)
+	}
+	if _, err := server.unwrap(token); err == nil {
+		t.Fatal("expected an error for a replayed token")
+	}
+}
+
+func TestSplitDownLevelName(t *testing.T) {
+	if domain, user := splitDownLevelName(`EXAMPLE\admin`, "example.com"); domain != "EXAMPLE" || user != "admin" {
+		t.Fatalf("unexpected domain %q and user %q", domain, user)
+	}
+	if domain, user := splitDownLevelName("admin", "example.com"); domain != "example.com" || user != "admin" {
+		t.Fatalf("unexpected domain %q and user %q", domain, user)
+	}
+}
+
+// testNTLMServer returns an NTLM handler accepting the given credentials. It
+// offers session security and seals the connection if the client requests it.
+func testNTLMServer(t *testing.T, domain, username, password string) func(*testSASLState, ber.Tag, []byte) (uint16, []byte) {
+	serverChallenge := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	timestamp := make([]byte, 8)
+	binary.LittleEndian.PutUint64(timestamp, 132000000000000000)
+	targetInfo := testNTLMAvPairs(2, ntlmUnicode("EXAMPLE"), ntlmAvTimestamp, timestamp)
+
+	return func(state *testSASLState, choice ber.Tag, message []byte) (uint16, []byte) {
+		if choice == sicilyNegotiate {
+			if !bytes.Equal(message[:8], ntlmSignature) || binary.LittleEndian.Uint32(message[8:]) != 1 {
+				t.Errorf("invalid negotiate message %x", message)
+				return ldap.LDAPResultProtocolError, nil
+			}
+			challenge := make([]byte, 48)
+			copy(challenge, ntlmSignature)
+			binary.LittleEndian.PutUint32(challenge[8:], 2)
+			binary.LittleEndian.PutUint32(challenge[20:], ntlmFlags|ntlmSessionSecurityFlags)
+			copy(challenge[24:], serverChallenge)
+			binary.LittleEndian.PutUint16(challenge[40:], uint16(len(targetInfo)))
+			binary.LittleEndian.PutUint16(challenge[42:], uint16(len(targetInfo)))
+			binary.LittleEndian.PutUint32(challenge[44:], 48)
+			return ldap.LDAPResultSuccess, append(challenge, targetInfo...)
+		}
+
+		lmResponse, _ := ntlmPayload(message, 12)
+		ntResponse, _ := ntlmPayload(message, 20)
+		messageDomain, _ := ntlmPayload(message, 28)
+		messageUser, _ := ntlmPayload(message, 36)
+		if !bytes.Equal(messageDomain, ntlmUnicode(domain)) || !bytes.Equal(messageUser, ntlmUnicode(username)) {
+			return ldap.LDAPResultInvalidCredentials, nil
+		}
+		if !bytes.Equal(lmResponse, make([]byte, 24)) {
+			t.Errorf("expected an empty LMv2 response, got %x", lmResponse)
+		}
+		if len(ntResponse) < 48 || !bytes.Equal(ntResponse[24:32], timestamp) {
+			t.Errorf("expected the server timestamp in the NTLMv2 response, got %x", ntResponse)
+			return ldap.LDAPResultInvalidCredentials, nil
+		}
+		expected, _, sessionBaseKey := ntlmv2Response(ntlmHash(password), username, domain, serverChallenge, ntResponse[32:40], timestamp, targetInfo)
+		if !bytes.Equal(ntResponse, expected) {
+			return ldap.LDAPResultInvalidCredentials, nil
+		}
+
+		if binary.LittleEndian.Uint32(message[60:])&ntlmNegotiateSeal != 0 {
+			encryptedSessionKey, _ := ntlmPayload(message, 52)
+			if len(encryptedSessionKey) != 16 {
+				t.Errorf("expected an encrypted session key, got %x", encryptedSessionKey)
+				return ldap.LDAPResultProtocolError, nil
+			}
+			sessionKey := make([]byte, 16)
+			cipher, _ := rc4.NewCipher(sessionBaseKey)
+			cipher.XORKeyStream(sessionKey, encryptedSessionKey)
+			session := newNTLMSession(sessionKey, false)
+			state.Wrap, state.Unwrap = session.wrap, session.unwrap
+		}
+		return ldap.LDAPResultSuccess, nil
+	}
+}
+
+func TestNTLMBind(t *testing.T) {
+	caPEM, cert := generateTestCertificates(t, "dc1.example.com")
+	server := newTestLDAPServer(t)
+	defer server.Close()
+	server.StartTLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.NTLM = testNTLMServer(t, "EXAMPLE", "admin", "secret")
+
+	config := Config{Username: `EXAMPLE\admin`, Domain: "example.com", Password: "secret", AuthMethod: authNTLM, Transport: transportStartTLS, CACert: caPEM}
+	connector, err := config.connector()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	conn, err := clientConnect([]string{server.Addr()}, connector)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Compare("cn=test,dc=example,dc=com", "cn", "test")
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+		t.Fatalf("expected the request to be answered, got %v", err)
+	}
+}
+
+func TestNTLMBind_wrongPassword(t *testing.T) {
+	caPEM, cert := generateTestCertificates(t, "dc1.example.com")
+	server := newTestLDAPServer(t)
+	defer server.Close()
+	server.StartTLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.NTLM = testNTLMServer(t, "EXAMPLE", "admin", "secret")
+
+	config := Config{Username: `EXAMPLE\admin`, Domain: "example.com", Password: "wrong", AuthMethod: authNTLM, Transport: transportStartTLS, CACert: caPEM}
+	connector, err := config.connector()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := clientConnect([]string{server.Addr()}, connector); err == nil {
+		t.Fatal("expected an error for a wrong password")
+	}
+}
+
+func TestNTLMBind_plainTransport(t *testing.T) {
+	server := newTestLDAPServer(t)
+	defer server.Close()
+	sealed := false
+	handler := testNTLMServer(t, "EXAMPLE", "admin", "secret")
+	server.NTLM = func(state *testSASLState, choice ber.Tag, message []byte) (uint16, []byte) {
+		code, result := handler(state, choice, message)
+		sealed = state.Wrap != nil
+		return code, result
+	}
+
+	config := Config{Username: `EXAMPLE\admin`, Domain: "example.com", Password: "secret", AuthMethod: authNTLM}
+	connector, err := config.connector()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	conn, err := clientConnect([]string{server.Addr()}, connector)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Compare("cn=test,dc=example,dc=com", "cn", "test")
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+		t.Fatalf("expected the sealed request to be answered, got %v", err)
+	}
+	if !sealed {
+		t.Fatal("expected the connection to be sealed")
+	}
+}
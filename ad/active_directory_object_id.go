@@ -0,0 +1,123 @@
+package ad
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// formatGUID returns the string form of an objectGUID. AD stores the first
+// three fields of a GUID in little-endian byte order.
+func formatGUID(raw []byte) (string, error) {
+	if len(raw) != 16 {
+		return "", fmt.Errorf("invalid objectGUID of %d bytes", len(raw))
+	}
+	guid, _ := uuid.FromBytes(swapGUIDBytes(raw))
+	return guid.String(), nil
+}
+
+// parseGUID returns the objectGUID of a GUID in the string form
+// xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx.
+func parseGUID(guid string) ([]byte, error) {
+	if len(guid) != 36 {
+		return nil, fmt.Errorf("invalid GUID %q", guid)
+	}
+	parsed, err := uuid.Parse(guid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GUID %q: %s", guid, err)
+	}
+	return swapGUIDBytes(parsed[:]), nil
+}
+
+// swapGUIDBytes converts between the byte order of objectGUID and RFC 4122.
+func swapGUIDBytes(b []byte) []byte {
+	return []byte{
+		b[3], b[2], b[1], b[0],
+		b[5], b[4],
+		b[7], b[6],
+		b[8], b[9], b[10], b[11], b[12], b[13], b[14], b[15],
+	}
+}
+
+// formatSID returns the string form S-1-5-21-... of an objectSid, see
+// MS-DTYP 2.4.2.
+func formatSID(raw []byte) (string, error) {
+	if len(raw) < 8 || len(raw) != 8+4*int(raw[1]) {
+		return "", fmt.Errorf("invalid objectSid of %d bytes", len(raw))
+	}
+	var authority uint64
+	for _, b := range raw[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+
+	sid := fmt.Sprintf("S-%d-%d", raw[0], authority)
+	for i := 8; i < len(raw); i += 4 {
+		sid += fmt.Sprintf("-%d", binary.LittleEndian.Uint32(raw[i:]))
+	}
+	return sid, nil
+}
+
+// parseSID returns the objectSid of a SID in the string form S-1-5-21-...
+func parseSID(sid string) ([]byte, error) {
+	parts := strings.Split(sid, "-")
+	if len(parts) < 3 || !strings.EqualFold(parts[0], "S") || len(parts) > 3+15 {
+		return nil, fmt.Errorf("invalid SID %q", sid)
+	}
+	revision, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SID %q: %s", sid, err)
+	}
+	authority, err := strconv.ParseUint(parts[2], 10, 48)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SID %q: %s", sid, err)
+	}
+
+	raw := make([]byte, 8, 8+4*(len(parts)-3))
+	raw[0] = byte(revision)
+	raw[1] = byte(len(parts) - 3)
+	for i := 7; i >= 2; i-- {
+		raw[i] = byte(authority)
+		authority >>= 8
+	}
+	for _, part := range parts[3:] {
+		subAuthority, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SID %q: %s", sid, err)
+		}
+		raw = append(raw, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(raw[len(raw)-4:], uint32(subAuthority))
+	}
+	return raw, nil
+}
+
+// objectIDStateUpgraders returns the state upgrade of resources identified
+// by the hex string of their objectGUID in schema version 0 to the string
+// form of the GUID.
+func objectIDStateUpgraders(resource *schema.Resource) []schema.StateUpgrader {
+	return []schema.StateUpgrader{
+		{
+			Version: 0,
+			Type:    resource.CoreConfigSchema().ImpliedType(),
+			Upgrade: upgradeHexObjectID,
+		},
+	}
+}
+
+func upgradeHexObjectID(rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	id, _ := rawState["id"].(string)
+	raw, err := hex.DecodeString(id)
+	if err != nil || len(raw) != 16 {
+		return rawState, nil
+	}
+	guid, err := formatGUID(raw)
+	if err != nil {
+		return nil, err
+	}
+	rawState["id"] = guid
+	return rawState, nil
+}
@@ -0,0 +1,90 @@
+package ad
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestFormatGUID(t *testing.T) {
+	raw, _ := hex.DecodeString("0102030405060708090a0b0c0d0e0f10")
+	guid, err := formatGUID(raw)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if guid != "04030201-0605-0807-090a-0b0c0d0e0f10" {
+		t.Fatalf("unexpected GUID %s", guid)
+	}
+
+	parsed, err := parseGUID(guid)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(parsed, raw) {
+		t.Fatalf("expected %x, got %x", raw, parsed)
+	}
+
+	if _, err := formatGUID(raw[:15]); err == nil {
+		t.Fatal("expected an error for a short objectGUID")
+	}
+	for _, invalid := range []string{"", "0102030405060708090a0b0c0d0e0f10", "{04030201-0605-0807-090a-0b0c0d0e0f}", "04030201-0605-0807-090a-0b0c0d0e0fxx"} {
+		if _, err := parseGUID(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestFormatSID(t *testing.T) {
+	cases := map[string]string{
+		"S-1-5-32-544": "01020000000000052000000020020000",
+		"S-1-5-21-1004336348-1177238915-682003330-512": "010500000000000515000000dcf4dc3b833d2b46828ba62800020000",
+		"S-1-1-0": "010100000000000100000000",
+	}
+	for sid, encoded := range cases {
+		raw, _ := hex.DecodeString(encoded)
+		formatted, err := formatSID(raw)
+		if err != nil {
+			t.Fatalf("%s: %s", sid, err)
+		}
+		if formatted != sid {
+			t.Errorf("expected %s, got %s", sid, formatted)
+		}
+
+		parsed, err := parseSID(sid)
+		if err != nil {
+			t.Fatalf("%s: %s", sid, err)
+		}
+		if !bytes.Equal(parsed, raw) {
+			t.Errorf("%s: expected %x, got %x", sid, raw, parsed)
+		}
+	}
+
+	if _, err := formatSID([]byte{1, 2, 0, 0, 0, 0, 0, 5, 32, 0, 0, 0}); err == nil {
+		t.Fatal("expected an error for a truncated objectSid")
+	}
+	for _, invalid := range []string{"", "S-1", "X-1-5-32", "S-1-5-x", "S-1-5-4294967296"} {
+		if _, err := parseSID(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestUpgradeHexObjectID(t *testing.T) {
+	state := map[string]interface{}{"id": "0102030405060708090a0b0c0d0e0f10", "name": "web01"}
+	upgraded, err := upgradeHexObjectID(state, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if upgraded["id"] != "04030201-0605-0807-090a-0b0c0d0e0f10" || upgraded["name"] != "web01" {
+		t.Fatalf("unexpected state %v", upgraded)
+	}
+
+	// states already holding a GUID stay as they are
+	upgraded, err = upgradeHexObjectID(map[string]interface{}{"id": "04030201-0605-0807-090a-0b0c0d0e0f10"}, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if upgraded["id"] != "04030201-0605-0807-090a-0b0c0d0e0f10" {
+		t.Fatalf("unexpected state %v", upgraded)
+	}
+}
@@ -2,7 +2,7 @@ package ad
 
 import ldap "gopkg.in/ldap.v3"
 
-func addOrgUnitToAD(orgUnitName string, dnName string, adConn *ldap.Conn, desc string) error {
+func addOrgUnitToAD(orgUnitName string, dnName string, adConn adClient, desc string) error {
 	addRequest := ldap.NewAddRequest(dnName, nil)
 	addRequest.Attribute("objectClass", []string{"organizationalunit"})
 	addRequest.Attribute("ou", []string{orgUnitName})
@@ -11,16 +11,16 @@ func addOrgUnitToAD(orgUnitName string, dnName string, adConn *ldap.Conn, desc s
 	}
 	err := adConn.Add(addRequest)
 	if err != nil {
-		return err
+		return describeADError(err, dnName, "")
 	}
 	return nil
 }
 
-func deleteOrgUnitFromAD(dnName string, adConn *ldap.Conn) error {
+func deleteOrgUnitFromAD(dnName string, adConn adClient) error {
 	delRequest := ldap.NewDelRequest(dnName, nil)
 	err := adConn.Del(delRequest)
 	if err != nil {
-		return err
+		return describeADError(err, dnName, "")
 	}
 	return nil
 }
@@ -0,0 +1,107 @@
+package ad
+
+import (
+	"log"
+	"time"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+// defaultRetryResultCodes are the result codes domain controllers return
+// while overloaded. Unwilling to perform is left out, AD mostly returns it
+// for permanent failures like password policy violations.
+var defaultRetryResultCodes = []uint16{
+	ldap.LDAPResultBusy,
+	ldap.LDAPResultUnavailable,
+}
+
+// retryPolicy decides whether and when a failed LDAP operation is repeated.
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	resultCodes []uint16
+
+	// sleep defaults to time.Sleep and is only overridden by tests.
+	sleep func(time.Duration)
+}
+
+// retryable reports whether the operation failed with one of the result
+// codes worth another attempt.
+func (p *retryPolicy) retryable(err error) bool {
+	for _, code := range p.resultCodes {
+		if ldap.IsErrorWithCode(err, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// do runs the operation until it succeeds, fails with an error that is not
+// retryable or the attempts are used up. The wait between attempts doubles
+// each time up to maxBackoff.
+func (p *retryPolicy) do(operation string, op func() error) error {
+	sleep := p.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	backoff := p.backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= p.maxAttempts || !p.retryable(err) {
+			return err
+		}
+
+		log.Printf("[WARN] %s failed (attempt %d of %d), retrying in %s: %s", operation, attempt, p.maxAttempts, backoff, err)
+		sleep(backoff)
+		backoff *= 2
+		if p.maxBackoff > 0 && backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
+
+// retryingClient is an adClient repeating operations according to a retryPolicy.
+type retryingClient struct {
+	client adClient
+	policy *retryPolicy
+}
+
+func (c *retryingClient) Add(request *ldap.AddRequest) error {
+	return c.policy.do("Adding "+request.DN, func() error {
+		return c.client.Add(request)
+	})
+}
+
+func (c *retryingClient) Del(request *ldap.DelRequest) error {
+	return c.policy.do("Deleting "+request.DN, func() error {
+		return c.client.Del(request)
+	})
+}
+
+func (c *retryingClient) Modify(request *ldap.ModifyRequest) error {
+	return c.policy.do("Modifying "+request.DN, func() error {
+		return c.client.Modify(request)
+	})
+}
+
+func (c *retryingClient) ModifyDN(request *ldap.ModifyDNRequest) error {
+	return c.policy.do("Renaming "+request.DN, func() error {
+		return c.client.ModifyDN(request)
+	})
+}
+
+func (c *retryingClient) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	var result *ldap.SearchResult
+	err := c.policy.do("Searching "+request.BaseDN, func() error {
+		var err error
+		result, err = c.client.Search(request)
+		return err
+	})
+	return result, err
+}
+
+func (c *retryingClient) Close() {
+	c.client.Close()
+}
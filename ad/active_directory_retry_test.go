@@ -0,0 +1,96 @@
+package ad
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	var waits []time.Duration
+	policy := &retryPolicy{
+		maxAttempts: 5,
+		backoff:     time.Second,
+		maxBackoff:  3 * time.Second,
+		resultCodes: defaultRetryResultCodes,
+		sleep: func(d time.Duration) {
+			waits = append(waits, d)
+		},
+	}
+
+	calls := 0
+	err := policy.do("Adding", func() error {
+		calls++
+		if calls < 4 {
+			return ldap.NewError(ldap.LDAPResultBusy, errors.New("busy"))
+		}
+		return nil
+	})
+	if err != nil || calls != 4 {
+		t.Fatalf("expected success after 4 attempts, got %v after %d", err, calls)
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if !reflect.DeepEqual(waits, expected) {
+		t.Fatalf("expected waits %v, got %v", expected, waits)
+	}
+
+	calls = 0
+	err = policy.do("Adding", func() error {
+		calls++
+		return ldap.NewError(ldap.LDAPResultUnavailable, errors.New("unavailable"))
+	})
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnavailable) || calls != 5 {
+		t.Fatalf("expected to give up after 5 attempts, got %v after %d", err, calls)
+	}
+
+	calls = 0
+	err = policy.do("Adding", func() error {
+		calls++
+		return ldap.NewError(ldap.LDAPResultEntryAlreadyExists, errors.New("exists"))
+	})
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) || calls != 1 {
+		t.Fatalf("expected no retry of a permanent error, got %v after %d", err, calls)
+	}
+}
+
+func TestRetryingClient(t *testing.T) {
+	server := newTestLDAPServer(t)
+	defer server.Close()
+
+	config := Config{Username: "admin", Domain: "example.com", Password: "secret", Transport: transportPlain}
+	connector, err := config.connector()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	conn, err := clientConnect([]string{server.Addr()}, connector)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	attempts := 0
+	config.RetryMaxAttempts = 2
+	config.RetryResultCodes = []int{ldap.LDAPResultUnwillingToPerform}
+	policy := config.retryPolicy()
+	policy.sleep = func(time.Duration) { attempts++ }
+	client := &retryingClient{client: conn, policy: policy}
+	defer client.Close()
+
+	// the test server is always unwilling to perform
+	err = client.Modify(ldap.NewModifyRequest("cn=test,dc=example,dc=com", nil))
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) || attempts != 1 {
+		t.Fatalf("expected one retry, got %v after %d retries", err, attempts)
+	}
+
+	// unwilling to perform is mostly permanent and not retried by default
+	attempts = 0
+	config.RetryResultCodes = nil
+	client.policy = config.retryPolicy()
+	client.policy.sleep = func(time.Duration) { attempts++ }
+	_, err = client.Search(ldap.NewSearchRequest("dc=example,dc=com", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil))
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) || attempts != 0 {
+		t.Fatalf("expected no retry for a result code not configured, got %v after %d retries", err, attempts)
+	}
+}
@@ -0,0 +1,193 @@
+package ad
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// saslBinder authenticates a freshly opened connection and returns the
+// connection the ldap package should use afterwards.
+type saslBinder interface {
+	bind(session *bindSession, server string) (net.Conn, error)
+}
+
+// bindSession speaks just enough LDAP on a fresh connection to run binds that
+// the ldap package does not support, before the connection is handed over.
+type bindSession struct {
+	conn      net.Conn
+	isTLS     bool
+	messageID int64
+}
+
+// request sends a single LDAP operation and returns the response operation.
+func (s *bindSession) request(op *ber.Packet) (*ber.Packet, error) {
+	s.messageID++
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, s.messageID, "MessageID"))
+	envelope.AppendChild(op)
+
+	if _, err := s.conn.Write(envelope.Bytes()); err != nil {
+		return nil, ldap.NewError(ldap.ErrorNetwork, err)
+	}
+
+	response, err := ber.ReadPacket(s.conn)
+	if err != nil {
+		return nil, ldap.NewError(ldap.ErrorNetwork, err)
+	}
+	if len(response.Children) < 2 || len(response.Children[1].Children) < 3 {
+		return nil, ldap.NewError(ldap.ErrorUnexpectedResponse, errors.New("ldap: malformed response"))
+	}
+	if id, ok := response.Children[0].Value.(int64); !ok || id != s.messageID {
+		return nil, ldap.NewError(ldap.ErrorUnexpectedResponse, fmt.Errorf("ldap: unexpected message id %v", response.Children[0].Value))
+	}
+	return response.Children[1], nil
+}
+
+// startTLS upgrades the connection using the StartTLS extended operation.
+func (s *bindSession) startTLS(config *tls.Config) error {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationExtendedRequest, nil, "Start TLS")
+	op.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, "1.3.6.1.4.1.1466.20037", "TLS Extended Command"))
+
+	response, err := s.request(op)
+	if err != nil {
+		return err
+	}
+	if code, diagnostic := resultCode(response); code != ldap.LDAPResultSuccess {
+		return ldap.NewError(code, errors.New(diagnostic))
+	}
+
+	conn := tls.Client(s.conn, config)
+	if err := conn.Handshake(); err != nil {
+		return ldap.NewError(ldap.ErrorNetwork, fmt.Errorf("TLS handshake failed (%v)", err))
+	}
+	s.conn = conn
+	s.isTLS = true
+	return nil
+}
+
+// saslBind sends one step of a SASL bind. It returns the server credentials
+// if the server either accepted the bind or asks for another step, and an
+// *ldap.Error otherwise.
+func (s *bindSession) saslBind(mechanism string, credentials []byte) (uint16, []byte, error) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationBindRequest, nil, "Bind Request")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 3, "Version"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "User Name"))
+	auth := ber.Encode(ber.ClassContext, ber.TypeConstructed, 3, nil, "SASL")
+	auth.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, mechanism, "Mechanism"))
+	if credentials != nil {
+		auth.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(credentials), "Credentials"))
+	}
+	op.AppendChild(auth)
+
+	response, err := s.request(op)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	code, diagnostic := resultCode(response)
+	if code != ldap.LDAPResultSuccess && code != ldap.LDAPResultSaslBindInProgress {
+		return code, nil, ldap.NewError(code, errors.New(diagnostic))
+	}
+
+	var serverCredentials []byte
+	for _, child := range response.Children[3:] {
+		if child.ClassType == ber.ClassContext && child.Tag == 7 {
+			serverCredentials = child.Data.Bytes()
+		}
+	}
+	return code, serverCredentials, nil
+}
+
+// resultCode extracts the result code and diagnostic message of a response.
+func resultCode(response *ber.Packet) (uint16, string) {
+	code, ok := response.Children[0].Value.(int64)
+	if !ok {
+		return ldap.ErrorUnexpectedResponse, "ldap: malformed result code"
+	}
+	diagnostic, _ := response.Children[2].Value.(string)
+	return uint16(code), diagnostic
+}
+
+// SASL security layers, see RFC 4752 section 3.3. Only integrity is
+// negotiated, confidentiality is left to TLS.
+const (
+	saslSecurityNone      = 1
+	saslSecurityIntegrity = 2
+)
+
+// saslMaxBuffer is the largest buffer we accept from the server.
+const saslMaxBuffer = 0xFFFFFF
+
+// saslSecurityLayerConn protects every message sent over a connection after a
+// SASL bind negotiated a security layer. Each buffer is sent as a 4 byte
+// length followed by the wrapped message.
+type saslSecurityLayerConn struct {
+	net.Conn
+	wrap      func([]byte) ([]byte, error)
+	unwrap    func([]byte) ([]byte, error)
+	maxBuffer int
+
+	readLock  sync.Mutex
+	pending   bytes.Buffer
+	writeLock sync.Mutex
+}
+
+func (c *saslSecurityLayerConn) Read(p []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	for c.pending.Len() == 0 {
+		var length [4]byte
+		if _, err := io.ReadFull(c.Conn, length[:]); err != nil {
+			return 0, err
+		}
+		size := binary.BigEndian.Uint32(length[:])
+		if size > saslMaxBuffer {
+			return 0, fmt.Errorf("SASL buffer of %d bytes exceeds the maximum size", size)
+		}
+		token := make([]byte, size)
+		if _, err := io.ReadFull(c.Conn, token); err != nil {
+			return 0, err
+		}
+		payload, err := c.unwrap(token)
+		if err != nil {
+			return 0, fmt.Errorf("unable to verify SASL buffer: %s", err)
+		}
+		c.pending.Write(payload)
+	}
+	return c.pending.Read(p)
+}
+
+func (c *saslSecurityLayerConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if c.maxBuffer > 0 && len(chunk) > c.maxBuffer {
+			chunk = chunk[:c.maxBuffer]
+		}
+		token, err := c.wrap(chunk)
+		if err != nil {
+			return written, err
+		}
+		buffer := make([]byte, 4+len(token))
+		binary.BigEndian.PutUint32(buffer, uint32(len(token)))
+		copy(buffer[4:], token)
+		if _, err := c.Conn.Write(buffer); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
@@ -0,0 +1,62 @@
+package ad
+
+import ldap "gopkg.in/ldap.v3"
+
+// getADEntry reads a single entry with a base-object search instead of
+// searching the whole domain. An entry with a known ID is looked up by its
+// objectGUID, so it is found even after it was renamed or moved. Without a
+// usable ID it falls back to the DN. Returns nil if there is no such entry
+// matching the filter.
+func getADEntry(id string, dn string, filter string, attributes []string, adConn adClient) (*ldap.Entry, error) {
+	baseDN := dn
+	if guidDN := guidBaseDN(id); guidDN != "" {
+		baseDN = guidDN
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		filter, attributes, []ldap.Control{&ldapControlServerExtendDN{}},
+	)
+	sr, err := adConn.Search(searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Entries) == 0 {
+		return nil, nil
+	}
+	return sr.Entries[0], nil
+}
+
+// guidBaseDN returns the <GUID=...> form of a DN AD accepts as search base
+// for an ID holding the string form of an objectGUID, or "" for other IDs.
+func guidBaseDN(id string) string {
+	if _, err := parseGUID(id); err != nil {
+		return ""
+	}
+	return "<GUID=" + id + ">"
+}
+
+// searchADEntries runs a search with the paged results control, so that it
+// returns more entries than the MaxPageSize of the DC.
+func searchADEntries(searchRequest *ldap.SearchRequest, pageSize uint32, adConn adClient) ([]*ldap.Entry, error) {
+	paging := ldap.NewControlPaging(pageSize)
+	searchRequest.Controls = append(searchRequest.Controls, paging)
+
+	var entries []*ldap.Entry
+	for {
+		sr, err := adConn.Search(searchRequest)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, sr.Entries...)
+
+		response, ok := ldap.FindControl(sr.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+		if !ok || len(response.Cookie) == 0 {
+			return entries, nil
+		}
+		paging.SetCookie(response.Cookie)
+	}
+}
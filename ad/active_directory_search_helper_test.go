@@ -0,0 +1,131 @@
+package ad
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+// testDirectoryClient returns a client connected to a server answering from
+// a new directory.
+func testDirectoryClient(t testing.TB) (*testDirectory, *ldap.Conn, func()) {
+	server := newTestLDAPServer(t)
+	directory := newTestDirectory()
+	directory.serve(server)
+
+	connector := &connector{transport: transportPlain, ldapPort: 389, username: "admin@example.com", password: "secret"}
+	conn, err := clientConnect([]string{server.Addr()}, connector)
+	if err != nil {
+		server.Close()
+		t.Fatalf("err: %s", err)
+	}
+	return directory, conn, func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+func TestGetADEntry(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	id := directory.add("cn=web01,dc=example,dc=com", map[string][]string{"objectClass": {"computer"}, "description": {"web server"}})
+	filter := filterEqual("objectClass", "computer")
+
+	// the ID takes precedence over an outdated DN
+	entry, err := getADEntry(id, "cn=web02,dc=example,dc=com", filter, []string{"description"}, conn)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if entry == nil || entry.GetAttributeValue("description") != "web server" {
+		t.Fatalf("unexpected entry %#v", entry)
+	}
+	if _, dn := parseExtendedDN(entry.DN); dn != "cn=web01,dc=example,dc=com" {
+		t.Fatalf("unexpected DN %s", dn)
+	}
+
+	for _, c := range []struct {
+		id     string
+		dn     string
+		filter string
+		found  bool
+	}{
+		{"", "CN=Web01,DC=example,DC=com", filter, true},
+		{"not-a-guid", "cn=web01,dc=example,dc=com", filter, true},
+		{"00000000-0000-0000-0000-000000000000", "cn=web01,dc=example,dc=com", filter, false},
+		{"", "cn=web02,dc=example,dc=com", filter, false},
+		{id, "cn=web01,dc=example,dc=com", filterEqual("objectClass", "group"), false},
+	} {
+		entry, err := getADEntry(c.id, c.dn, c.filter, []string{"description"}, conn)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if (entry != nil) != c.found {
+			t.Errorf("expected the lookup of %q, %q to find an entry: %t", c.id, c.dn, c.found)
+		}
+	}
+}
+
+func TestGuidBaseDN(t *testing.T) {
+	if dn := guidBaseDN("6f1d5e2c-3b4a-4c5d-8e9f-0a1b2c3d4e5f"); dn != "<GUID=6f1d5e2c-3b4a-4c5d-8e9f-0a1b2c3d4e5f>" {
+		t.Fatalf("unexpected DN %s", dn)
+	}
+	for _, id := range []string{"", "0123", "0123456789abcdef0123456789abcdef", "not a guid at all, not a guid at all"} {
+		if dn := guidBaseDN(id); dn != "" {
+			t.Errorf("expected no GUID DN for %q, got %s", id, dn)
+		}
+	}
+}
+
+// BenchmarkReadEntry compares looking up an entry by its objectGUID with a
+// base-object search to the subtree search over the whole domain.
+func BenchmarkReadEntry(b *testing.B) {
+	directory, conn, done := testDirectoryClient(b)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=Computers,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	var id string
+	for i := 0; i < 20000; i++ {
+		id = directory.add(fmt.Sprintf("cn=host%05d,ou=Computers,dc=example,dc=com", i), map[string][]string{"objectClass": {"computer"}})
+	}
+	dn := "cn=host19999,ou=Computers,dc=example,dc=com"
+	attributes := []string{"cn", "description"}
+
+	b.Run("subtree", func(b *testing.B) {
+		guid, _ := parseGUID(id)
+		for i := 0; i < b.N; i++ {
+			searchRequest := ldap.NewSearchRequest(
+				"dc=example,dc=com", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+				filterAnd(filterEqual("objectClass", "computer"), testFilterBinary("objectGUID", guid)),
+				attributes, []ldap.Control{&ldapControlServerExtendDN{}},
+			)
+			sr, err := conn.Search(searchRequest)
+			if err != nil || len(sr.Entries) != 1 {
+				b.Fatalf("unexpected result %v, %v", sr, err)
+			}
+		}
+	})
+
+	b.Run("guid", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			entry, err := getADEntry(id, dn, filterEqual("objectClass", "computer"), attributes, conn)
+			if err != nil || entry == nil {
+				b.Fatalf("unexpected result %v, %v", entry, err)
+			}
+		}
+	})
+}
+
+// testFilterBinary returns an equality filter matching the raw bytes of a
+// binary attribute, like the subtree search for a GUID did.
+func testFilterBinary(attribute string, value []byte) string {
+	var escaped strings.Builder
+	for _, b := range value {
+		fmt.Fprintf(&escaped, "\\%02x", b)
+	}
+	return fmt.Sprintf("(%s=%s)", attribute, escaped.String())
+}
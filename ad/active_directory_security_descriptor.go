@@ -0,0 +1,247 @@
+package ad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/go-asn1-ber/asn1-ber"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// Security descriptor control flags, access control entry types and rights
+// used to edit the DACL of an object.
+const (
+	sdDACLPresent  = 0x0004
+	sdSACLPresent  = 0x0010
+	sdSelfRelative = 0x8000
+
+	aclRevisionDS = 4
+
+	aceTypeAccessDeniedObject = 0x06
+	aceObjectTypePresent      = 0x1
+	adsRightDSControlAccess   = 0x100
+
+	// daclSecurityInformation asks for the DACL only, which users may read
+	// and write without the privilege needed for the SACL.
+	daclSecurityInformation = 0x4
+)
+
+// changePasswordRight is the GUID of the User-Change-Password extended right.
+const changePasswordRight = "ab721a53-1e2f-11d0-9819-00aa0040529b"
+
+// ldapControlSDFlags implements ldap.Control, it selects the parts of the
+// nTSecurityDescriptor which are read or written.
+type ldapControlSDFlags struct {
+	Critical bool
+	Flags    int
+}
+
+// GetControlType implements ldap.Control
+func (c *ldapControlSDFlags) GetControlType() string {
+	return "1.2.840.113556.1.4.801"
+}
+
+// Encode implements ldap.Control
+func (c *ldapControlSDFlags) Encode() *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, c.GetControlType(), "Control Type (LDAP_SERVER_SD_FLAGS_OID)"))
+	packet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, c.Critical, "Criticality"))
+
+	p2 := ber.Encode(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, nil, "Control Value (SD Flags)")
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "SDFlagsRequestValue")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, c.Flags, "Flags"))
+	p2.AppendChild(seq)
+	packet.AppendChild(p2)
+
+	return packet
+}
+
+// String implements ldap.Control
+func (c *ldapControlSDFlags) String() string {
+	return fmt.Sprintf("SD flags %d: %s", c.Flags, c.GetControlType())
+}
+
+// securityDescriptor is the DACL of a self-relative security descriptor as
+// read with the DACL security information flag.
+type securityDescriptor struct {
+	control     uint16
+	aclRevision byte
+	dacl        []*accessControlEntry
+}
+
+// accessControlEntry is an ACE of a DACL. Only object ACEs denying access
+// are decoded, the others are kept as they are.
+type accessControlEntry struct {
+	raw        []byte
+	aceType    byte
+	objectType []byte
+	sid        []byte
+}
+
+// parseSecurityDescriptor decodes the DACL of a self-relative security
+// descriptor.
+func parseSecurityDescriptor(raw []byte) (*securityDescriptor, error) {
+	if len(raw) < 20 || raw[0] != 1 {
+		return nil, fmt.Errorf("invalid security descriptor")
+	}
+	sd := &securityDescriptor{control: binary.LittleEndian.Uint16(raw[2:4]), aclRevision: 2}
+	if sd.control&sdSelfRelative == 0 {
+		return nil, fmt.Errorf("security descriptor is not self-relative")
+	}
+	offset := int(binary.LittleEndian.Uint32(raw[16:20]))
+	if sd.control&sdDACLPresent == 0 || offset == 0 {
+		return nil, fmt.Errorf("security descriptor has no DACL")
+	}
+	if offset+8 > len(raw) {
+		return nil, fmt.Errorf("invalid DACL offset %d", offset)
+	}
+
+	acl := raw[offset:]
+	sd.aclRevision = acl[0]
+	size := int(binary.LittleEndian.Uint16(acl[2:4]))
+	count := int(binary.LittleEndian.Uint16(acl[4:6]))
+	if size < 8 || size > len(acl) {
+		return nil, fmt.Errorf("invalid DACL size %d", size)
+	}
+	acl = acl[8:size]
+	for i := 0; i < count; i++ {
+		if len(acl) < 4 {
+			return nil, fmt.Errorf("DACL holds less than %d ACEs", count)
+		}
+		aceSize := int(binary.LittleEndian.Uint16(acl[2:4]))
+		if aceSize < 4 || aceSize > len(acl) {
+			return nil, fmt.Errorf("invalid ACE size %d", aceSize)
+		}
+		ace, err := parseAccessControlEntry(acl[:aceSize])
+		if err != nil {
+			return nil, err
+		}
+		sd.dacl = append(sd.dacl, ace)
+		acl = acl[aceSize:]
+	}
+	return sd, nil
+}
+
+func parseAccessControlEntry(raw []byte) (*accessControlEntry, error) {
+	ace := &accessControlEntry{raw: raw, aceType: raw[0]}
+	if ace.aceType != aceTypeAccessDeniedObject {
+		return ace, nil
+	}
+	if len(raw) < 12 {
+		return nil, fmt.Errorf("invalid object ACE")
+	}
+	flags := binary.LittleEndian.Uint32(raw[8:12])
+	rest := raw[12:]
+	if flags&aceObjectTypePresent != 0 {
+		if len(rest) < 16 {
+			return nil, fmt.Errorf("invalid object ACE")
+		}
+		ace.objectType, rest = rest[:16], rest[16:]
+	}
+	if flags&0x2 != 0 {
+		if len(rest) < 16 {
+			return nil, fmt.Errorf("invalid object ACE")
+		}
+		rest = rest[16:]
+	}
+	ace.sid = rest
+	return ace, nil
+}
+
+// deniedObjectACE returns an ACE denying the trustee the control access
+// right given by its GUID.
+func deniedObjectACE(sid []byte, objectType []byte) *accessControlEntry {
+	raw := make([]byte, 28, 28+len(sid))
+	raw[0] = aceTypeAccessDeniedObject
+	binary.LittleEndian.PutUint16(raw[2:4], uint16(28+len(sid)))
+	binary.LittleEndian.PutUint32(raw[4:8], adsRightDSControlAccess)
+	binary.LittleEndian.PutUint32(raw[8:12], aceObjectTypePresent)
+	copy(raw[12:28], objectType)
+	raw = append(raw, sid...)
+	return &accessControlEntry{raw: raw, aceType: aceTypeAccessDeniedObject, objectType: objectType, sid: sid}
+}
+
+// denies reports whether the DACL holds an ACE denying the trustee the
+// control access right given by its GUID.
+func (sd *securityDescriptor) denies(sid []byte, objectType []byte) bool {
+	for _, ace := range sd.dacl {
+		if ace.aceType == aceTypeAccessDeniedObject && bytes.Equal(ace.sid, sid) && bytes.Equal(ace.objectType, objectType) {
+			return true
+		}
+	}
+	return false
+}
+
+// setDenied adds or removes the ACE denying the trustee the control access
+// right given by its GUID. Denying ACEs go first, as in canonical order.
+func (sd *securityDescriptor) setDenied(sid []byte, objectType []byte, denied bool) {
+	if denied {
+		if !sd.denies(sid, objectType) {
+			sd.dacl = append([]*accessControlEntry{deniedObjectACE(sid, objectType)}, sd.dacl...)
+			if sd.aclRevision < aclRevisionDS {
+				sd.aclRevision = aclRevisionDS
+			}
+		}
+		return
+	}
+	var dacl []*accessControlEntry
+	for _, ace := range sd.dacl {
+		if ace.aceType != aceTypeAccessDeniedObject || !bytes.Equal(ace.sid, sid) || !bytes.Equal(ace.objectType, objectType) {
+			dacl = append(dacl, ace)
+		}
+	}
+	sd.dacl = dacl
+}
+
+// bytes encodes the security descriptor holding just the DACL, to be written
+// with the DACL security information flag.
+func (sd *securityDescriptor) bytes() []byte {
+	size := 8
+	for _, ace := range sd.dacl {
+		size += len(ace.raw)
+	}
+
+	raw := make([]byte, 20, 20+size)
+	raw[0] = 1
+	binary.LittleEndian.PutUint16(raw[2:4], (sd.control|sdDACLPresent|sdSelfRelative)&^sdSACLPresent)
+	binary.LittleEndian.PutUint32(raw[16:20], 20)
+
+	acl := make([]byte, 8)
+	acl[0] = sd.aclRevision
+	binary.LittleEndian.PutUint16(acl[2:4], uint16(size))
+	binary.LittleEndian.PutUint16(acl[4:6], uint16(len(sd.dacl)))
+	raw = append(raw, acl...)
+	for _, ace := range sd.dacl {
+		raw = append(raw, ace.raw...)
+	}
+	return raw
+}
+
+// getSecurityDescriptor reads the DACL of the entry.
+func getSecurityDescriptor(dn string, adConn adClient) (*securityDescriptor, error) {
+	searchRequest := ldap.NewSearchRequest(
+		dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		filterPresent("objectClass"), []string{"nTSecurityDescriptor"},
+		[]ldap.Control{&ldapControlSDFlags{Flags: daclSecurityInformation}},
+	)
+	sr, err := adConn.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Entries) == 0 {
+		return nil, fmt.Errorf("%s was not found", dn)
+	}
+	return parseSecurityDescriptor(sr.Entries[0].GetRawAttributeValue("nTSecurityDescriptor"))
+}
+
+// setSecurityDescriptor replaces the DACL of the entry.
+func setSecurityDescriptor(dn string, sd *securityDescriptor, adConn adClient) error {
+	modifyRequest := ldap.NewModifyRequest(dn, []ldap.Control{&ldapControlSDFlags{Flags: daclSecurityInformation}})
+	modifyRequest.Replace("nTSecurityDescriptor", []string{string(sd.bytes())})
+	err := adConn.Modify(modifyRequest)
+	if err != nil {
+		return describeADError(err, dn, "nTSecurityDescriptor")
+	}
+	return nil
+}
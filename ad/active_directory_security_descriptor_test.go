@@ -0,0 +1,87 @@
+package ad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestSecurityDescriptor(t *testing.T) {
+	// a security descriptor with owner and group in front of the DACL, as
+	// returned without the SD flags control
+	owner, _ := parseSID("S-1-5-21-1004336348-1177238915-682003330-512")
+	everyone, _ := parseSID("S-1-1-0")
+	allow := make([]byte, 8, 8+len(everyone))
+	binary.LittleEndian.PutUint16(allow[2:4], uint16(8+len(everyone)))
+	binary.LittleEndian.PutUint32(allow[4:8], 0x20094)
+	allow = append(allow, everyone...)
+
+	raw := make([]byte, 20)
+	raw[0] = 1
+	binary.LittleEndian.PutUint16(raw[2:4], sdSelfRelative|sdDACLPresent|0x0400)
+	binary.LittleEndian.PutUint32(raw[4:8], 20)
+	binary.LittleEndian.PutUint32(raw[8:12], uint32(20+len(owner)))
+	binary.LittleEndian.PutUint32(raw[16:20], uint32(20+2*len(owner)))
+	raw = append(raw, owner...)
+	raw = append(raw, owner...)
+	acl := make([]byte, 8)
+	acl[0] = 2
+	binary.LittleEndian.PutUint16(acl[2:4], uint16(8+len(allow)))
+	binary.LittleEndian.PutUint16(acl[4:6], 1)
+	raw = append(raw, append(acl, allow...)...)
+
+	sd, err := parseSecurityDescriptor(raw)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(sd.dacl) != 1 || !bytes.Equal(sd.dacl[0].raw, allow) {
+		t.Fatalf("unexpected DACL %v", sd.dacl)
+	}
+
+	self, _ := parseSID("S-1-5-10")
+	right, _ := parseGUID(changePasswordRight)
+	sd.setDenied(self, right, true)
+	sd.setDenied(self, right, true)
+	if len(sd.dacl) != 2 || !sd.denies(self, right) || sd.denies(everyone, right) {
+		t.Fatalf("expected a single ACE denying SELF, got %v", sd.dacl)
+	}
+
+	// the DACL survives encoding, denying ACEs first
+	parsed, err := parseSecurityDescriptor(sd.bytes())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(parsed.dacl) != 2 || !parsed.denies(self, right) || !bytes.Equal(parsed.dacl[1].raw, allow) {
+		t.Fatalf("unexpected DACL %v", parsed.dacl)
+	}
+	if parsed.aclRevision != aclRevisionDS || parsed.control&0x0400 == 0 {
+		t.Fatalf("unexpected revision %d or control %x", parsed.aclRevision, parsed.control)
+	}
+
+	parsed.setDenied(self, right, false)
+	if len(parsed.dacl) != 1 || parsed.denies(self, right) {
+		t.Fatalf("expected the ACE denying SELF to be removed, got %v", parsed.dacl)
+	}
+}
+
+func TestParseSecurityDescriptor_invalid(t *testing.T) {
+	valid := testSecurityDescriptor()
+	noDACL := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(noDACL[2:4], sdSelfRelative)
+	badSize := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(badSize[22:24], 200)
+	badCount := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(badCount[24:26], 2)
+
+	for name, raw := range map[string][]byte{
+		"empty":     nil,
+		"truncated": valid[:10],
+		"no DACL":   noDACL,
+		"ACL size":  badSize,
+		"ACE count": badCount,
+	} {
+		if _, err := parseSecurityDescriptor(raw); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
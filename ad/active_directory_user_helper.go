@@ -2,12 +2,45 @@ package ad
 
 import (
 	"fmt"
+	"strconv"
 
 	"golang.org/x/text/encoding/unicode"
 	ldap "gopkg.in/ldap.v3"
 )
 
-func addUserToAD(UserName string, firstname string, lastname string, dnName string, adConn *ldap.Conn, desc string) error {
+// userAttribute is a string argument of users stored in a single valued
+// attribute of the user.
+type userAttribute struct {
+	key         string
+	attribute   string
+	description string
+}
+
+// userAttributes are the directory attributes of users managed by ad_user
+// besides the names and the description.
+var userAttributes = []userAttribute{
+	{"principal_name", "userPrincipalName", "The user principal name (UPN) of the user, e.g. jdoe@example.com"},
+	{"email", "mail", "The email address of the user"},
+	{"title", "title", "The job title of the user"},
+	{"department", "department", "The department of the user"},
+	{"company", "company", "The company of the user"},
+	{"employee_id", "employeeID", "The employee ID of the user"},
+	{"employee_number", "employeeNumber", "The employee number of the user"},
+	{"telephone_number", "telephoneNumber", "The telephone number of the user"},
+	{"mobile", "mobile", "The mobile phone number of the user"},
+	{"office", "physicalDeliveryOfficeName", "The office of the user"},
+	{"street_address", "streetAddress", "The street address of the user"},
+	{"po_box", "postOfficeBox", "The post office box of the user"},
+	{"city", "l", "The city of the user"},
+	{"state", "st", "The state or province of the user"},
+	{"postal_code", "postalCode", "The postal code of the user"},
+	{"country", "c", "The two letter ISO 3166 code of the country of the user"},
+	{"manager", "manager", "The DN of the manager of the user"},
+}
+
+// addUserToAD adds a disabled user. attributes holds the values of further
+// attributes, empty ones are left out.
+func addUserToAD(UserName string, firstname string, lastname string, dnName string, adConn adClient, desc string, attributes map[string]string) error {
 	userFullName := fmt.Sprintf("%s %s", firstname, lastname)
 	addRequest := ldap.NewAddRequest(dnName, nil)
 	addRequest.Attribute("objectClass", []string{"user"})
@@ -21,14 +54,19 @@ func addUserToAD(UserName string, firstname string, lastname string, dnName stri
 	if desc != "" {
 		addRequest.Attribute("description", []string{desc})
 	}
+	for _, a := range userAttributes {
+		if value := attributes[a.attribute]; value != "" {
+			addRequest.Attribute(a.attribute, []string{value})
+		}
+	}
 	err := adConn.Add(addRequest)
 	if err != nil {
-		return err
+		return describeADError(err, dnName, "")
 	}
 	return nil
 }
 
-func setUserPassword(dnName string, password string, adConn *ldap.Conn) error {
+func setUserPassword(dnName string, password string, adConn adClient) error {
 	utf16 := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
 	// The password needs to be enclosed in quotes
 	pwdEncoded, err := utf16.NewEncoder().String(fmt.Sprintf("\"%s\"", password))
@@ -36,10 +74,12 @@ func setUserPassword(dnName string, password string, adConn *ldap.Conn) error {
 		return err
 	}
 
+	// Replacing the password resets it like an administrator does, unlike
+	// deleting the old and adding the new one it needs no current password.
 	passwordModifyRequest := &ldap.ModifyRequest{
 		DN: dnName, // DN for the user we're resetting
 		Changes: []ldap.Change{{
-			Operation: ldap.AddAttribute,
+			Operation: ldap.ReplaceAttribute,
 			Modification: ldap.PartialAttribute{
 				Type: "unicodePwd",
 				Vals: []string{pwdEncoded},
@@ -48,34 +88,162 @@ func setUserPassword(dnName string, password string, adConn *ldap.Conn) error {
 	}
 	err = adConn.Modify(passwordModifyRequest)
 	if err != nil {
-		return err
+		return describeADError(err, dnName, "unicodePwd")
 	}
 	return nil
 }
 
-func activateUser(dnName string, adConn *ldap.Conn) error {
-	activateUserRequest := &ldap.ModifyRequest{
-		DN: dnName, // DN for the user we're resetting
-		Changes: []ldap.Change{{
-			Operation: ldap.ReplaceAttribute,
-			Modification: ldap.PartialAttribute{
-				Type: "userAccountControl",
-				Vals: []string{"512"},
-			},
-		}},
+// setPasswordExpired makes the user change the password at the next logon by
+// setting pwdLastSet to 0. -1 sets it to the current time instead, so that
+// the password is valid again.
+func setPasswordExpired(dnName string, expired bool, adConn adClient) error {
+	pwdLastSet := "-1"
+	if expired {
+		pwdLastSet = "0"
 	}
-	err := adConn.Modify(activateUserRequest)
+	err := updateADEntry(dnName, "pwdLastSet", pwdLastSet, adConn)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func deleteUserFromAD(dnName string, adConn *ldap.Conn) error {
+// setAccountExpires sets the expiration date of the account, an RFC 3339
+// timestamp or "never".
+func setAccountExpires(dnName string, accountExpires string, adConn adClient) error {
+	fileTime, err := parseAccountExpires(accountExpires)
+	if err != nil {
+		return err
+	}
+	return updateADEntry(dnName, "accountExpires", fileTime, adConn)
+}
+
+// Flags of the userAccountControl of users.
+const (
+	uacAccountDisable             = 0x2
+	uacPasswordNotRequired        = 0x20
+	uacNormalAccount              = 0x200
+	uacDontExpirePassword         = 0x10000
+	uacSmartcardRequired          = 0x40000
+	uacTrustedForDelegation       = 0x80000
+	uacNotDelegated               = 0x100000
+	uacUseDESKeyOnly              = 0x200000
+	uacDontRequirePreauth         = 0x400000
+	uacTrustedToAuthForDelegation = 0x1000000
+)
+
+// userAccountControlFlag is a boolean argument of users backed by a flag of
+// the userAccountControl. An inverted argument is true if the flag is clear.
+type userAccountControlFlag struct {
+	key      string
+	flag     uint32
+	inverted bool
+}
+
+// value returns the value of the argument for the given userAccountControl.
+func (f userAccountControlFlag) value(uac uint32) bool {
+	return (uac&f.flag != 0) != f.inverted
+}
+
+// userAccountControlFlags are the flags managed by ad_user, the others are
+// kept as they are.
+var userAccountControlFlags = []userAccountControlFlag{
+	{"enabled", uacAccountDisable, true},
+	{"password_not_required", uacPasswordNotRequired, false},
+	{"password_never_expires", uacDontExpirePassword, false},
+	{"smartcard_required", uacSmartcardRequired, false},
+	{"trusted_for_delegation", uacTrustedForDelegation, false},
+	{"trusted_to_auth_for_delegation", uacTrustedToAuthForDelegation, false},
+	{"not_delegated", uacNotDelegated, false},
+	{"use_des_key_only", uacUseDESKeyOnly, false},
+	{"dont_require_preauth", uacDontRequirePreauth, false},
+}
+
+// updateUserAccountControl sets and clears the given flags of the
+// userAccountControl of the user, keeping the other flags as they are. It
+// writes nothing if no flag changes.
+func updateUserAccountControl(dnName string, set uint32, clear uint32, adConn adClient) error {
+	entry, err := getADEntry("", dnName, filterEqual("objectClass", "User"), []string{"userAccountControl"}, adConn)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("%s was not found", dnName)
+	}
+	value, err := strconv.ParseUint(entry.GetAttributeValue("userAccountControl"), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid userAccountControl %q of %s", entry.GetAttributeValue("userAccountControl"), dnName)
+	}
+
+	uac := uint32(value)&^clear | set
+	if uac == uint32(value) {
+		return nil
+	}
+	return updateADEntry(dnName, "userAccountControl", strconv.FormatUint(uint64(uac), 10), adConn)
+}
+
+// cannotChangePasswordTrustees are the SIDs of Everyone and SELF, AD keeps
+// users from changing their password by denying both the right to.
+var cannotChangePasswordTrustees = []string{"S-1-1-0", "S-1-5-10"}
+
+// getUserCannotChangePassword reports whether the DACL of the user denies
+// changing the password.
+func getUserCannotChangePassword(dnName string, adConn adClient) (bool, error) {
+	sd, err := getSecurityDescriptor(dnName, adConn)
+	if err != nil {
+		return false, err
+	}
+	right, _ := parseGUID(changePasswordRight)
+	for _, trustee := range cannotChangePasswordTrustees {
+		sid, _ := parseSID(trustee)
+		if !sd.denies(sid, right) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// setUserCannotChangePassword adds or removes the ACEs denying the user to
+// change the password.
+func setUserCannotChangePassword(dnName string, cannotChange bool, adConn adClient) error {
+	sd, err := getSecurityDescriptor(dnName, adConn)
+	if err != nil {
+		return err
+	}
+	right, _ := parseGUID(changePasswordRight)
+	for _, trustee := range cannotChangePasswordTrustees {
+		sid, _ := parseSID(trustee)
+		sd.setDenied(sid, right, cannotChange)
+	}
+	return setSecurityDescriptor(dnName, sd, adConn)
+}
+
+func deleteUserFromAD(dnName string, adConn adClient) error {
 	delRequest := ldap.NewDelRequest(dnName, nil)
 	err := adConn.Del(delRequest)
 	if err != nil {
-		return err
+		return describeADError(err, dnName, "")
 	}
 	return nil
 }
+
+// searchADUser looks up a user by its sAMAccountName below the given base DN.
+// Returns nil if there is no such user.
+func searchADUser(username string, baseDN string, attributes []string, adConn adClient) (*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filterAnd(filterEqual("objectClass", "User"), filterEqual("sAMAccountName", username)),
+		attributes, []ldap.Control{&ldapControlServerExtendDN{}},
+	)
+	sr, err := adConn.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Entries) == 0 {
+		return nil, nil
+	}
+	if len(sr.Entries) > 1 {
+		return nil, fmt.Errorf("found ambigious values for user: %s", username)
+	}
+	return sr.Entries[0], nil
+}
@@ -2,51 +2,587 @@ package ad
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"gopkg.in/ldap.v3"
+	"io/ioutil"
 	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/ldap.v3"
 )
 
 type Config struct {
-	Domain   string
-	IP       string
-	Username string
-	Password string
-	UseSSL   bool
+	Domain      string
+	IP          string
+	Servers     []string
+	DiscoverSRV bool
+	Username    string
+	Password    string
+	BindDN      string
+	BindFormat  string
+	UseSSL      bool
+	Transport   string
+	LDAPPort    int
+	LDAPSPort   int
+
+	PasswordFile      string
+	CredentialProcess string
+
+	MaxConnections int
+	RequestTimeout time.Duration
+
+	RetryMaxAttempts int
+	RetryBackoff     time.Duration
+	RetryMaxBackoff  time.Duration
+	RetryResultCodes []int
+
+	CACertFile         string
+	CACert             string
+	TLSServerName      string
+	TLSMinVersion      string
+	InsecureSkipVerify bool
+
+	AuthMethod string
+	Kerberos   KerberosConfig
+
+	// lookupSRV resolves DNS SRV records. Defaults to net.LookupSRV and is
+	// only overridden by tests.
+	lookupSRV func(service, proto, name string) (string, []*net.SRV, error)
 }
 
-// Client() returns a connection for accessing AD services.
-func (c *Config) Client() (*ldap.Conn, error) {
-	var username string
-	username = c.Username + "@" + c.Domain
-	adConn, err := clientConnect(c.IP, username, c.Password, c.UseSSL)
+// Client() returns a client for accessing AD services, which spreads the
+// operations over up to MaxConnections connections, reconnects if a
+// connection gets closed and retries operations failing transiently.
+func (c *Config) Client() (adClient, error) {
+	if err := c.loadCredentials(); err != nil {
+		return nil, fmt.Errorf("Error while reading the credentials: %s", err)
+	}
+
+	servers, err := c.serverList()
+	if err != nil {
+		return nil, fmt.Errorf("Error while trying to determine the active directory servers: %s", err)
+	}
+
+	connector, err := c.connector()
+	if err != nil {
+		return nil, fmt.Errorf("Error while preparing the connection settings: %s", err)
+	}
+
+	adConn, err := newConnectionPool(c.MaxConnections, func(preferred string) (*ldap.Conn, string, error) {
+		return connectFirst(preferServer(servers, preferred), connector)
+	})
 
 	if err != nil {
 		return nil, fmt.Errorf("Error while trying to connect active directory server, Check server IP address, username or password: %s", err)
 	}
 	log.Printf("[DEBUG] AD connection successful for user: %s", c.Username)
+	return &retryingClient{client: adConn, policy: c.retryPolicy()}, nil
+}
+
+// retryPolicy returns the retry settings, retrying on the result codes of an
+// overloaded domain controller unless others were configured.
+func (c *Config) retryPolicy() *retryPolicy {
+	policy := &retryPolicy{
+		maxAttempts: c.RetryMaxAttempts,
+		backoff:     c.RetryBackoff,
+		maxBackoff:  c.RetryMaxBackoff,
+		resultCodes: defaultRetryResultCodes,
+	}
+	if len(c.RetryResultCodes) > 0 {
+		policy.resultCodes = make([]uint16, 0, len(c.RetryResultCodes))
+		for _, code := range c.RetryResultCodes {
+			policy.resultCodes = append(policy.resultCodes, uint16(code))
+		}
+	}
+	return policy
+}
+
+// supported values of the transport setting
+const (
+	transportPlain    = "plain"
+	transportLDAPS    = "ldaps"
+	transportStartTLS = "starttls"
+)
+
+// transport returns the configured transport, falling back to the legacy
+// ssl flag if none was set.
+func (c *Config) transport() string {
+	if c.Transport != "" {
+		return c.Transport
+	}
+	if c.UseSSL {
+		return transportLDAPS
+	}
+	return transportPlain
+}
+
+// supported values of the auth_method setting
+const (
+	authSimple   = "simple"
+	authKerberos = "kerberos"
+	authNTLM     = "ntlm"
+)
+
+// connector returns the settings used to dial and bind to each AD server.
+func (c *Config) connector() (*connector, error) {
+	conn := &connector{
+		transport: c.transport(),
+		ldapPort:  c.LDAPPort,
+		ldapsPort: c.LDAPSPort,
+		password:  c.Password,
+
+		requestTimeout: c.RequestTimeout,
+	}
+	if conn.ldapPort == 0 {
+		conn.ldapPort = 389
+	}
+	if conn.ldapsPort == 0 {
+		conn.ldapsPort = 636
+	}
+
+	switch conn.transport {
+	case transportPlain:
+	case transportLDAPS, transportStartTLS:
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		conn.tlsConfig = tlsConfig
+	default:
+		return nil, fmt.Errorf("unsupported transport %q", conn.transport)
+	}
+
+	if c.AuthMethod != authSimple && c.AuthMethod != "" && (c.BindDN != "" || c.BindFormat != "") {
+		return nil, fmt.Errorf("bind_dn and bind_format are only supported by the simple auth method")
+	}
+
+	switch c.AuthMethod {
+	case authSimple, "":
+		if c.Password == "" {
+			return nil, fmt.Errorf("a password is required for the simple bind")
+		}
+		username, err := c.bindIdentity()
+		if err != nil {
+			return nil, err
+		}
+		conn.username = username
+	case authKerberos:
+		cl, err := c.kerberosClient()
+		if err != nil {
+			return nil, err
+		}
+		conn.binder = &gssapiBinder{
+			spn: c.Kerberos.SPN,
+			newClient: func() (gssapiClient, error) {
+				return newKrb5Context(cl), nil
+			},
+		}
+	case authNTLM:
+		if c.Password == "" {
+			return nil, fmt.Errorf("a password is required for the NTLM bind")
+		}
+		domain, username := splitDownLevelName(c.Username, c.Domain)
+		conn.binder = &ntlmBinder{
+			domain:   domain,
+			username: username,
+			password: c.Password,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported auth method %q", c.AuthMethod)
+	}
+	return conn, nil
+}
+
+// supported values of the bind_format setting
+const (
+	bindFormatUPN       = "upn"
+	bindFormatDownLevel = "downlevel"
+	bindFormatDN        = "dn"
+	bindFormatVerbatim  = "verbatim"
+)
+
+// bindIdentity returns the name used for the simple bind. A bind_dn is used
+// as is, otherwise the user is formatted according to bind_format, which
+// defaults to a user principal name in the domain.
+func (c *Config) bindIdentity() (string, error) {
+	if c.BindDN != "" {
+		if _, err := ldap.ParseDN(c.BindDN); err != nil {
+			return "", fmt.Errorf("invalid bind_dn %q: %s", c.BindDN, err)
+		}
+		return c.BindDN, nil
+	}
+
+	switch c.BindFormat {
+	case bindFormatUPN, "":
+		upn := c.Username
+		if !strings.Contains(upn, "@") {
+			upn = c.Username + "@" + c.Domain
+		}
+		if parts := strings.Split(upn, "@"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", fmt.Errorf("invalid user principal name %q, expected user@suffix", upn)
+		}
+		return upn, nil
+	case bindFormatDownLevel:
+		name := c.Username
+		if !strings.Contains(name, "\\") {
+			netbios := strings.ToUpper(strings.Split(c.Domain, ".")[0])
+			name = netbios + "\\" + c.Username
+		}
+		if parts := strings.Split(name, "\\"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", fmt.Errorf("invalid down-level logon name %q, expected DOMAIN\\user", name)
+		}
+		return name, nil
+	case bindFormatDN:
+		dn, err := ldap.ParseDN(c.Username)
+		if err != nil || len(dn.RDNs) == 0 {
+			return "", fmt.Errorf("invalid distinguished name %q for the dn bind format", c.Username)
+		}
+		return c.Username, nil
+	case bindFormatVerbatim:
+		if c.Username == "" {
+			return "", fmt.Errorf("a user is required for the verbatim bind format")
+		}
+		return c.Username, nil
+	}
+	return "", fmt.Errorf("unsupported bind format %q", c.BindFormat)
+}
+
+// tlsMinVersions maps the supported tls_min_version values to their constants.
+var tlsMinVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsConfig returns the TLS settings used for connecting to the AD servers.
+// The server name is filled in per server by serverTLSConfig unless it was
+// configured explicitly.
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.TLSServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if c.TLSMinVersion != "" {
+		version, ok := tlsMinVersions[c.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS version %q", c.TLSMinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if c.CACertFile != "" || c.CACert != "" {
+		pool := x509.NewCertPool()
+		if c.CACertFile != "" {
+			pem, err := ioutil.ReadFile(c.CACertFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read CA certificate file: %s", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no PEM encoded certificate found in %s", c.CACertFile)
+			}
+		}
+		if c.CACert != "" && !pool.AppendCertsFromPEM([]byte(c.CACert)) {
+			return nil, fmt.Errorf("no PEM encoded certificate found in ca_cert")
+		}
+		config.RootCAs = pool
+	}
+
+	if c.InsecureSkipVerify {
+		log.Printf("[WARN] TLS certificate verification of the AD servers is disabled")
+	}
+	return config, nil
+}
+
+// serverList returns the domain controllers to try in order: the configured
+// ip, the configured servers and finally the ones discovered via DNS SRV records.
+func (c *Config) serverList() ([]string, error) {
+	var servers []string
+	if c.IP != "" {
+		servers = append(servers, c.IP)
+	}
+	servers = append(servers, c.Servers...)
+
+	if c.DiscoverSRV {
+		discovered, err := c.discoverServers()
+		if err != nil {
+			if len(servers) == 0 {
+				return nil, err
+			}
+			log.Printf("[WARN] Ignoring failed DNS SRV discovery, using configured servers only: %s", err)
+		}
+		servers = append(servers, discovered...)
+	}
+
+	result := make([]string, 0, len(servers))
+	for _, server := range servers {
+		if server != "" && !itemExists(result, server) {
+			result = append(result, server)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no server configured, set either ip, servers or discover_servers")
+	}
+	log.Printf("[DEBUG] AD servers to try: %s", result)
+	return result, nil
+}
+
+// discoverServers looks up the _ldap._tcp SRV records of the domain and returns
+// the target hosts in the order net.LookupSRV returns them, which sorts them
+// by priority and randomizes them by weight as RFC 2782 describes. The port
+// of the records is used unless ldap_port was configured, the ldaps
+// transport uses ldaps_port since the records advertise the LDAP port.
+func (c *Config) discoverServers() ([]string, error) {
+	lookupSRV := c.lookupSRV
+	if lookupSRV == nil {
+		lookupSRV = net.LookupSRV
+	}
+
+	_, records, err := lookupSRV("ldap", "tcp", c.Domain)
+	if err != nil {
+		return nil, fmt.Errorf("DNS SRV lookup of _ldap._tcp.%s failed: %s", c.Domain, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("DNS SRV lookup of _ldap._tcp.%s returned no records", c.Domain)
+	}
+
+	useRecordPort := c.transport() != transportLDAPS && c.LDAPPort == 0
+	servers := make([]string, 0, len(records))
+	for _, record := range records {
+		server := strings.TrimSuffix(record.Target, ".")
+		if useRecordPort && record.Port != 0 {
+			server = net.JoinHostPort(server, strconv.Itoa(int(record.Port)))
+		}
+		servers = append(servers, server)
+	}
+	log.Printf("[DEBUG] Discovered AD servers for %s: %s", c.Domain, servers)
+	return servers, nil
+}
+
+// serverAddress appends the default port to a server unless it already has one.
+func serverAddress(server string, defaultPort int) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, strconv.Itoa(defaultPort))
+}
+
+// connector dials and binds to a single AD server.
+type connector struct {
+	transport string
+	ldapPort  int
+	ldapsPort int
+	tlsConfig *tls.Config
+	username  string
+	password  string
+
+	// requestTimeout bounds every request sent over the connection if set.
+	requestTimeout time.Duration
+
+	// binder authenticates new connections instead of a simple bind if set.
+	binder saslBinder
+}
+
+// clientConnect tries the given servers in order and returns the first
+// connection that could be established and bound successfully.
+func clientConnect(servers []string, conn *connector) (*ldap.Conn, error) {
+	adConn, _, err := connectFirst(servers, conn)
+	return adConn, err
+}
+
+// connectFirst is clientConnect also returning the server connected to.
+func connectFirst(servers []string, conn *connector) (*ldap.Conn, string, error) {
+	var failures []string
+	for _, server := range servers {
+		adConn, err := conn.connect(server)
+		if err == nil {
+			log.Printf("[DEBUG] Connected to AD server: %s", server)
+			return adConn, server, nil
+		}
+		log.Printf("[WARN] Unable to connect to AD server %s, trying next one: %s", server, err)
+		failures = append(failures, fmt.Sprintf("%s: %s", server, err))
+	}
+	return nil, "", fmt.Errorf("no server could be reached (%s)", strings.Join(failures, "; "))
+}
+
+// preferServer moves the preferred server to the front of the list.
+func preferServer(servers []string, preferred string) []string {
+	if preferred == "" {
+		return servers
+	}
+	result := []string{preferred}
+	for _, server := range servers {
+		if server != preferred {
+			result = append(result, server)
+		}
+	}
+	return result
+}
+
+func (c *connector) connect(server string) (*ldap.Conn, error) {
+	if c.binder != nil {
+		return c.saslConnect(server)
+	}
+
+	adConn, err := c.dial(server)
+	if err != nil {
+		return nil, err
+	}
+	adConn.SetTimeout(c.requestTimeout)
+
+	err = adConn.Bind(c.username, c.password)
+	if err != nil {
+		adConn.Close()
+		return nil, describeADError(err, c.username, "")
+	}
 	return adConn, nil
 }
 
-func clientConnect(ip, username, password string, useSSL bool) (*ldap.Conn, error) {
-	var adConn *ldap.Conn
-	var err error
-	if useSSL {
-		adConn, err = ldap.DialTLS("tcp", fmt.Sprintf("%s:%d", ip, 636), &tls.Config{
-			InsecureSkipVerify: true,
-			ServerName:         ip,
-		})
-	} else {
-		adConn, err = ldap.Dial("tcp", fmt.Sprintf("%s:%d", ip, 389))
+// dial opens a connection to the server using the configured transport.
+func (c *connector) dial(server string) (*ldap.Conn, error) {
+	if c.transport == transportPlain {
+		return ldap.Dial("tcp", serverAddress(server, c.ldapPort))
 	}
 
+	config, verifyErr := serverTLSConfig(c.tlsConfig, server)
+
+	if c.transport == transportLDAPS {
+		adConn, err := ldap.DialTLS("tcp", serverAddress(server, c.ldapsPort), config)
+		if err != nil {
+			return nil, describeTLSError(err, *verifyErr, config.ServerName)
+		}
+		return adConn, nil
+	}
+
+	adConn, err := ldap.Dial("tcp", serverAddress(server, c.ldapPort))
 	if err != nil {
 		return nil, err
 	}
+	err = adConn.StartTLS(config)
+	if err != nil {
+		adConn.Close()
+		return nil, describeTLSError(err, *verifyErr, config.ServerName)
+	}
+	return adConn, nil
+}
 
-	err = adConn.Bind(username, password)
+// saslConnect opens a connection and authenticates it with the binder before
+// handing it over to the ldap package.
+func (c *connector) saslConnect(server string) (*ldap.Conn, error) {
+	session, err := c.openSession(server)
 	if err != nil {
 		return nil, err
 	}
+
+	if c.requestTimeout > 0 {
+		session.conn.SetDeadline(time.Now().Add(c.requestTimeout))
+	}
+	conn, err := c.binder.bind(session, server)
+	if err != nil {
+		session.conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	adConn := ldap.NewConn(conn, session.isTLS)
+	adConn.SetTimeout(c.requestTimeout)
+	adConn.Start()
 	return adConn, nil
 }
+
+// openSession opens a connection using the configured transport without
+// handing it over to the ldap package.
+func (c *connector) openSession(server string) (*bindSession, error) {
+	if c.transport == transportLDAPS {
+		config, verifyErr := serverTLSConfig(c.tlsConfig, server)
+		dialer := &net.Dialer{Timeout: ldap.DefaultTimeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", serverAddress(server, c.ldapsPort), config)
+		if err != nil {
+			return nil, describeTLSError(ldap.NewError(ldap.ErrorNetwork, err), *verifyErr, config.ServerName)
+		}
+		return &bindSession{conn: conn, isTLS: true}, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", serverAddress(server, c.ldapPort), ldap.DefaultTimeout)
+	if err != nil {
+		return nil, ldap.NewError(ldap.ErrorNetwork, err)
+	}
+	session := &bindSession{conn: conn}
+
+	if c.transport == transportStartTLS {
+		config, verifyErr := serverTLSConfig(c.tlsConfig, server)
+		if err := session.startTLS(config); err != nil {
+			session.conn.Close()
+			return nil, describeTLSError(err, *verifyErr, config.ServerName)
+		}
+	}
+	return session, nil
+}
+
+// serverTLSConfig returns the TLS settings for the given server. The
+// certificate is verified by a callback recording the verification error,
+// since the ldap package only passes on its text.
+func serverTLSConfig(tlsConfig *tls.Config, server string) (*tls.Config, *error) {
+	var verifyErr error
+	config := tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = server
+		if host, _, err := net.SplitHostPort(server); err == nil {
+			config.ServerName = host
+		}
+	}
+
+	if !config.InsecureSkipVerify {
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			verifyErr = verifyServerCertificate(state, config.RootCAs, config.ServerName)
+			return verifyErr
+		}
+	}
+	return config, &verifyErr
+}
+
+// verifyServerCertificate performs the verification crypto/tls does if
+// InsecureSkipVerify is not set.
+func verifyServerCertificate(state tls.ConnectionState, roots *x509.CertPool, serverName string) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("the server did not present a certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// describeTLSError turns certificate validation failures into errors telling
+// which provider setting needs to be adjusted.
+func describeTLSError(err error, verifyErr error, serverName string) error {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+
+	switch {
+	case verifyErr == nil:
+		return err
+	case errors.As(verifyErr, &unknownAuthority):
+		return fmt.Errorf("the TLS certificate of %s is signed by an unknown authority, set ca_cert or ca_cert_file to the issuing CA: %s", serverName, verifyErr)
+	case errors.As(verifyErr, &hostname):
+		return fmt.Errorf("the TLS certificate is not valid for %s, set tls_server_name to a name it was issued for: %s", serverName, verifyErr)
+	case errors.As(verifyErr, &invalid):
+		return fmt.Errorf("the TLS certificate of %s is not valid: %s", serverName, verifyErr)
+	}
+	return fmt.Errorf("the TLS certificate of %s could not be verified: %s", serverName, verifyErr)
+}
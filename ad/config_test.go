@@ -0,0 +1,374 @@
+package ad
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+func TestConfigServerList(t *testing.T) {
+	config := Config{
+		Domain:      "example.com",
+		IP:          "10.0.0.1",
+		Servers:     []string{"dc1.example.com", "10.0.0.1"},
+		DiscoverSRV: true,
+		lookupSRV: func(service, proto, name string) (string, []*net.SRV, error) {
+			if service != "ldap" || proto != "tcp" || name != "example.com" {
+				return "", nil, fmt.Errorf("unexpected lookup of _%s._%s.%s", service, proto, name)
+			}
+			return "", []*net.SRV{
+				{Target: "dc2.example.com.", Priority: 10},
+				{Target: "dc1.example.com.", Priority: 0},
+			}, nil
+		},
+	}
+
+	servers, err := config.serverList()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []string{"10.0.0.1", "dc1.example.com", "dc2.example.com"}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Fatalf("expected %v, got %v", expected, servers)
+	}
+}
+
+func TestConfigDiscoverServers(t *testing.T) {
+	records := []*net.SRV{
+		{Target: "dc2.example.com.", Port: 3268, Priority: 0, Weight: 10},
+		{Target: "dc1.example.com.", Port: 389, Priority: 0, Weight: 50},
+	}
+	lookupSRV := func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", records, nil
+	}
+
+	cases := []struct {
+		config   Config
+		expected []string
+	}{
+		// the order of net.LookupSRV is kept, it is randomized by weight
+		{Config{}, []string{"dc2.example.com:3268", "dc1.example.com:389"}},
+		{Config{Transport: transportStartTLS}, []string{"dc2.example.com:3268", "dc1.example.com:389"}},
+		// a configured port wins over the one of the records
+		{Config{LDAPPort: 1389}, []string{"dc2.example.com", "dc1.example.com"}},
+		// the records advertise the LDAP port, not the LDAPS one
+		{Config{Transport: transportLDAPS}, []string{"dc2.example.com", "dc1.example.com"}},
+	}
+	for _, tc := range cases {
+		tc.config.Domain = "example.com"
+		tc.config.lookupSRV = lookupSRV
+		servers, err := tc.config.discoverServers()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !reflect.DeepEqual(servers, tc.expected) {
+			t.Errorf("%#v: expected %v, got %v", tc.config, tc.expected, servers)
+		}
+	}
+}
+
+func TestConfigServerList_discoveryFailure(t *testing.T) {
+	config := Config{
+		Domain:      "example.com",
+		DiscoverSRV: true,
+		lookupSRV: func(service, proto, name string) (string, []*net.SRV, error) {
+			return "", nil, fmt.Errorf("no such host")
+		},
+	}
+
+	if _, err := config.serverList(); err == nil {
+		t.Fatal("expected an error without any configured or discovered server")
+	}
+
+	config.Servers = []string{"dc1.example.com"}
+	servers, err := config.serverList()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(servers, config.Servers) {
+		t.Fatalf("expected %v, got %v", config.Servers, servers)
+	}
+}
+
+func TestPreferServer(t *testing.T) {
+	servers := []string{"dc1.example.com", "dc2.example.com", "dc3.example.com"}
+	expected := []string{"dc2.example.com", "dc1.example.com", "dc3.example.com"}
+	if result := preferServer(servers, "dc2.example.com"); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %v, got %v", expected, result)
+	}
+	if result := preferServer(servers, ""); !reflect.DeepEqual(result, servers) {
+		t.Fatalf("expected %v, got %v", servers, result)
+	}
+}
+
+func TestClientConnect_failover(t *testing.T) {
+	// a port nobody listens on anymore
+	closed := newTestLDAPServer(t)
+	closed.Close()
+
+	rejecting := newTestLDAPServer(t)
+	defer rejecting.Close()
+	rejecting.Bind = func(name, password string) uint16 {
+		return ldap.LDAPResultInvalidCredentials
+	}
+
+	accepting := newTestLDAPServer(t)
+	defer accepting.Close()
+	accepting.Bind = func(name, password string) uint16 {
+		if name != "admin@example.com" || password != "secret" {
+			return ldap.LDAPResultInvalidCredentials
+		}
+		return ldap.LDAPResultSuccess
+	}
+
+	config := Config{Username: "admin", Domain: "example.com", Password: "secret", Transport: transportPlain}
+	connector, err := config.connector()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	conn, err := clientConnect([]string{closed.Addr(), rejecting.Addr(), accepting.Addr()}, connector)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	conn.Close()
+
+	if rejecting.Binds() != 1 || accepting.Binds() != 1 {
+		t.Fatalf("expected one bind per reachable server, got %d and %d", rejecting.Binds(), accepting.Binds())
+	}
+}
+
+func TestClientConnect_allFailing(t *testing.T) {
+	rejecting := newTestLDAPServer(t)
+	defer rejecting.Close()
+	rejecting.Bind = func(name, password string) uint16 {
+		return ldap.LDAPResultInvalidCredentials
+	}
+
+	config := Config{Username: "admin", Domain: "example.com", Password: "wrong", Transport: transportPlain}
+	connector, err := config.connector()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := clientConnect([]string{rejecting.Addr()}, connector); err == nil {
+		t.Fatal("expected an error if no server accepts the bind")
+	}
+}
+
+// generateTestCertificates returns a PEM encoded CA certificate and a server
+// certificate issued by it for the given DNS names and 127.0.0.1.
+func generateTestCertificates(t *testing.T, dnsNames ...string) (string, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "dc1.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return string(caPEM), tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+}
+
+func TestClientConnect_tls(t *testing.T) {
+	caPEM, cert := generateTestCertificates(t, "dc1.example.com")
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	ldapsServer := newTestLDAPServerTLS(t, serverTLS)
+	defer ldapsServer.Close()
+
+	startTLSServer := newTestLDAPServer(t)
+	defer startTLSServer.Close()
+	startTLSServer.StartTLS = serverTLS
+
+	cases := []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{"trusted CA", Config{CACert: caPEM}, ""},
+		{"trusted CA with server name", Config{CACert: caPEM, TLSServerName: "dc1.example.com"}, ""},
+		{"unknown CA", Config{}, "unknown authority"},
+		{"wrong server name", Config{CACert: caPEM, TLSServerName: "dc2.example.com"}, "set tls_server_name"},
+		{"insecure", Config{InsecureSkipVerify: true}, ""},
+	}
+
+	for _, transport := range []string{transportLDAPS, transportStartTLS} {
+		server := ldapsServer
+		if transport == transportStartTLS {
+			server = startTLSServer
+		}
+
+		for _, tc := range cases {
+			tc.config.Username = "admin"
+			tc.config.Domain = "example.com"
+			tc.config.Password = "secret"
+			tc.config.Transport = transport
+			connector, err := tc.config.connector()
+			if err != nil {
+				t.Fatalf("%s/%s: err: %s", transport, tc.name, err)
+			}
+			conn, err := clientConnect([]string{server.Addr()}, connector)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("%s/%s: err: %s", transport, tc.name, err)
+				}
+				if _, ok := conn.TLSConnectionState(); !ok {
+					t.Fatalf("%s/%s: expected a TLS connection", transport, tc.name)
+				}
+				conn.Close()
+				continue
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%s/%s: expected error containing %q, got %v", transport, tc.name, tc.err, err)
+			}
+		}
+	}
+}
+
+func TestConfigConnector(t *testing.T) {
+	cases := []struct {
+		config    Config
+		transport string
+		tls       bool
+	}{
+		{Config{}, transportPlain, false},
+		{Config{UseSSL: true}, transportLDAPS, true},
+		{Config{UseSSL: true, Transport: transportStartTLS}, transportStartTLS, true},
+		{Config{Transport: transportPlain}, transportPlain, false},
+	}
+
+	for _, tc := range cases {
+		tc.config.Username = "admin"
+		tc.config.Domain = "example.com"
+		tc.config.Password = "secret"
+		connector, err := tc.config.connector()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if connector.transport != tc.transport || (connector.tlsConfig != nil) != tc.tls {
+			t.Fatalf("expected transport %s (tls: %t), got %s (tls: %t)", tc.transport, tc.tls, connector.transport, connector.tlsConfig != nil)
+		}
+		if connector.ldapPort != 389 || connector.ldapsPort != 636 {
+			t.Fatalf("unexpected default ports %d and %d", connector.ldapPort, connector.ldapsPort)
+		}
+	}
+
+	config := Config{Transport: "ldapi", Password: "secret"}
+	if _, err := config.connector(); err == nil {
+		t.Fatal("expected an error for an unsupported transport")
+	}
+
+	config = Config{AuthMethod: authSimple}
+	if _, err := config.connector(); err == nil {
+		t.Fatal("expected an error for a simple bind without password")
+	}
+}
+
+func TestConfigBindIdentity(t *testing.T) {
+	cases := []struct {
+		config   Config
+		identity string
+	}{
+		{Config{Username: "admin"}, "admin@example.com"},
+		{Config{Username: "admin@corp.example.org"}, "admin@corp.example.org"},
+		{Config{Username: "admin", BindFormat: bindFormatDownLevel}, `EXAMPLE\admin`},
+		{Config{Username: `CORP\admin`, BindFormat: bindFormatDownLevel}, `CORP\admin`},
+		{Config{Username: "cn=admin,cn=Users,dc=example,dc=com", BindFormat: bindFormatDN}, "cn=admin,cn=Users,dc=example,dc=com"},
+		{Config{Username: "admin", BindFormat: bindFormatVerbatim}, "admin"},
+		{Config{Username: "admin", BindDN: "cn=svc,ou=Services,dc=example,dc=com"}, "cn=svc,ou=Services,dc=example,dc=com"},
+	}
+
+	for _, tc := range cases {
+		tc.config.Domain = "example.com"
+		identity, err := tc.config.bindIdentity()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if identity != tc.identity {
+			t.Fatalf("expected %q, got %q", tc.identity, identity)
+		}
+	}
+
+	invalid := []Config{
+		{Username: "admin@"},
+		{Username: `CORP\a\b`, BindFormat: bindFormatDownLevel},
+		{Username: "admin", BindFormat: bindFormatDN},
+		{Username: "admin", BindDN: "not a dn"},
+		{Username: "admin", BindFormat: "email"},
+	}
+	for _, config := range invalid {
+		config.Domain = "example.com"
+		if identity, err := config.bindIdentity(); err == nil {
+			t.Fatalf("expected an error for %#v, got %q", config, identity)
+		}
+	}
+
+	config := Config{Username: "admin", Password: "secret", BindFormat: bindFormatDN}
+	if _, err := config.connector(); err == nil {
+		t.Fatal("expected the bind identity to be validated")
+	}
+}
+
+func TestConfigTLSConfig(t *testing.T) {
+	config := Config{TLSMinVersion: "1.3"}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 || tlsConfig.InsecureSkipVerify {
+		t.Fatalf("unexpected TLS config: %#v", tlsConfig)
+	}
+
+	config = Config{CACert: "not a certificate"}
+	if _, err := config.tlsConfig(); err == nil {
+		t.Fatal("expected an error for an invalid CA certificate")
+	}
+}
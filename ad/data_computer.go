@@ -30,6 +30,11 @@ func dataActiveDirectoryComputer() *schema.Resource {
 				Optional:    true,
 				Default:     nil,
 			},
+			"sid": {
+				Type:        schema.TypeString,
+				Description: "The security identifier (SID) of the computer",
+				Computed:    true,
+			},
 		},
 	}
 }
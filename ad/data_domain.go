@@ -5,8 +5,6 @@ import (
 	"log"
 	"strings"
 
-	ldap "gopkg.in/ldap.v3"
-
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
@@ -29,6 +27,11 @@ func dataActiveDirectoryDomain() *schema.Resource {
 				Description: "The distinguished name of the domain",
 				Computed:    true,
 			},
+			"sid": {
+				Type:        schema.TypeString,
+				Description: "The security identifier (SID) of the domain",
+				Computed:    true,
+			},
 		},
 	}
 }
@@ -37,56 +40,50 @@ func resourceADDomainRead(d *schema.ResourceData, meta interface{}) error {
 	domainName := d.Get("name").(string)
 	parent := d.Get("parent").(string)
 
-	dnOfDomain := "dc=" + domainName
+	dnOfDomain := rdnString("dc", domainName)
 	if parent != "" {
 		domainArr := strings.Split(parent, ".")
 		for _, item := range domainArr {
-			dnOfDomain += ",dc=" + item
+			dnOfDomain += "," + rdnString("dc", item)
 		}
 	}
 
 	log.Printf("[DEBUG] Name of the DN is : %s ", dnOfDomain)
 	log.Printf("[DEBUG] Searching the domain from the AD : %s ", domainName)
 
-	client := meta.(*ldap.Conn)
-
-	searchParam := "(distinguishedName=" + dnOfDomain + ")"
-
-	if d.Id() != "" {
-		searchParam = "(objectGUID=" + generateObjectIdQueryString(d.Id()) + ")"
-	}
-
-	log.Printf("[DEBUG] Search Parameters for domain: %s ", searchParam)
-
-	searchRequest := ldap.NewSearchRequest(
-		dnOfDomain, // The base dn to search
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(&(objectClass=domain)"+searchParam+")", // The filter to apply
-		[]string{"dn", "dc"},                     // A list attributes to retrieve
-		nil,
-	)
+	client := meta.(adClient)
 
-	searchRequest.Controls = append(searchRequest.Controls, &ldapControlServerExtendDN{})
-
-	sr, err := client.Search(searchRequest)
+	domainRecord, err := getADEntry(d.Id(), dnOfDomain, filterEqual("objectClass", "domain"), []string{"dc", "objectGUID", "objectSid"}, client)
 	if err != nil {
 		log.Printf("[ERROR] Error while searching a domain: %s", err)
 		return fmt.Errorf("Error while searching a domain: %s", err)
 	}
-	if len(sr.Entries) == 0 {
+	if domainRecord == nil {
 		log.Println("[ERROR] Domain was not found")
 		d.SetId("")
-	} else {
-		if len(sr.Entries) > 1 {
-			log.Printf("[ERROR] Error found ambigious values for domain: %s", domainName)
-			return fmt.Errorf("Error found ambigious values for domain: %s", domainName)
-		}
-		domainRecord := sr.Entries[0]
-		domainID, domainDN := parseExtendedDN(domainRecord.DN)
-		d.SetId(domainID)
-		d.Set("dn", domainDN)
-		d.Set("name", domainRecord.GetAttributeValue("dc"))
-		d.Set("parent", extractDomainFromDN(domainDN))
+		return nil
+	}
+
+	_, domainDN := parseExtendedDN(domainRecord.DN)
+	domainID, err := formatGUID(domainRecord.GetRawAttributeValue("objectGUID"))
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the objectGUID of the domain: %s", err)
+		return fmt.Errorf("Error while reading the objectGUID of the domain: %s", err)
+	}
+	sid, err := formatSID(domainRecord.GetRawAttributeValue("objectSid"))
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the objectSid of the domain: %s", err)
+		return fmt.Errorf("Error while reading the objectSid of the domain: %s", err)
+	}
+	dn, err := parseDN(domainDN)
+	if err != nil {
+		log.Printf("[ERROR] Error while parsing the DN of the domain: %s", err)
+		return fmt.Errorf("Error while parsing the DN of the domain: %s", err)
 	}
+	d.SetId(domainID)
+	d.Set("dn", domainDN)
+	d.Set("sid", sid)
+	d.Set("name", domainRecord.GetAttributeValue("dc"))
+	d.Set("parent", dn.Domain().String())
 	return nil
 }
@@ -26,9 +26,9 @@ func dataActiveDirectoryGroup() *schema.Resource {
 			},
 			"type": {
 				Type:        schema.TypeString,
-				Description: "The type of the group. Could be either GLOBAL or LOCAL. Defaults to GLOBAL.",
+				Description: "The type of the group, e.g. GLOBAL, LOCAL or UNIVERSAL",
 				Optional:    true,
-				Default:     "GLOBAL",
+				Computed:    true,
 			},
 			"dn": {
 				Type:        schema.TypeString,
@@ -36,6 +36,11 @@ func dataActiveDirectoryGroup() *schema.Resource {
 				Optional:    true,
 				Default:     nil,
 			},
+			"sid": {
+				Type:        schema.TypeString,
+				Description: "The security identifier (SID) of the group",
+				Computed:    true,
+			},
 			"members": {
 				Type: schema.TypeSet,
 				Elem: &schema.Schema{
@@ -5,7 +5,7 @@ import (
 )
 
 func dataActiveDirectoryUser() *schema.Resource {
-	return &schema.Resource{
+	resource := &schema.Resource{
 		Read: resourceADUserRead,
 		Schema: map[string]*schema.Schema{
 			"username": {
@@ -43,6 +43,66 @@ func dataActiveDirectoryUser() *schema.Resource {
 				Description: "The distinguished name of the user",
 				Computed:    true,
 			},
+			"sid": {
+				Type:        schema.TypeString,
+				Description: "The security identifier (SID) of the user",
+				Computed:    true,
+			},
+			"password_never_expires": {
+				Type:        schema.TypeBool,
+				Description: "Whether the password of the user never expires",
+				Computed:    true,
+			},
+			"enabled": {
+				Type:        schema.TypeBool,
+				Description: "Whether the account of the user is enabled",
+				Computed:    true,
+			},
+			"password_not_required": {
+				Type:        schema.TypeBool,
+				Description: "Whether the user may have an empty password",
+				Computed:    true,
+			},
+			"smartcard_required": {
+				Type:        schema.TypeBool,
+				Description: "Whether the user has to log on with a smart card",
+				Computed:    true,
+			},
+			"trusted_for_delegation": {
+				Type:        schema.TypeBool,
+				Description: "Whether services running as the user are trusted for Kerberos delegation",
+				Computed:    true,
+			},
+			"trusted_to_auth_for_delegation": {
+				Type:        schema.TypeBool,
+				Description: "Whether services running as the user may use protocol transition for constrained delegation",
+				Computed:    true,
+			},
+			"not_delegated": {
+				Type:        schema.TypeBool,
+				Description: "Whether the credentials of the user are never delegated, even to services trusted for delegation",
+				Computed:    true,
+			},
+			"use_des_key_only": {
+				Type:        schema.TypeBool,
+				Description: "Whether the user is restricted to DES encryption for Kerberos",
+				Computed:    true,
+			},
+			"dont_require_preauth": {
+				Type:        schema.TypeBool,
+				Description: "Whether the user may log on without Kerberos pre-authentication",
+				Computed:    true,
+			},
+			"account_expires": {
+				Type:        schema.TypeString,
+				Description: "The date the account expires as RFC 3339 timestamp, or never",
+				Computed:    true,
+			},
+			"cannot_change_password": {
+				Type:        schema.TypeBool,
+				Description: "Whether the user is denied to change the password",
+				Computed:    true,
+			},
 			"groups": {
 				Type: schema.TypeSet,
 				Elem: &schema.Schema{
@@ -52,4 +112,12 @@ func dataActiveDirectoryUser() *schema.Resource {
 			},
 		},
 	}
+	for _, a := range userAttributes {
+		resource.Schema[a.key] = &schema.Schema{
+			Type:        schema.TypeString,
+			Description: a.description,
+			Computed:    true,
+		}
+	}
+	return resource
 }
@@ -0,0 +1,766 @@
+package ad
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// testDirectory is an in-memory directory a testLDAPServer answers from. Like
+// AD it accepts <GUID=...> as base DN, returns extended DNs if asked to,
+// maintains the memberOf back-links of group members and only ever writes
+// unicodePwd.
+type testDirectory struct {
+	lock    sync.Mutex
+	entries []*testEntry
+	byGUID  map[string]*testEntry
+	nextRID uint32
+}
+
+// testDomainSID is the SID of the domain the security principals of a
+// testDirectory belong to.
+const testDomainSID = "S-1-5-21-1004336348-1177238915-682003330"
+
+type testEntry struct {
+	dn         *distinguishedName
+	guid       []byte
+	attributes []*ldap.EntryAttribute
+
+	// password is the last password written to unicodePwd, it is never
+	// returned by searches.
+	password string
+}
+
+func newTestDirectory() *testDirectory {
+	return &testDirectory{byGUID: make(map[string]*testEntry), nextRID: 1000}
+}
+
+// serve lets the server answer searches and updates from the directory.
+func (d *testDirectory) serve(server *testLDAPServer) {
+	server.Search = d.search
+	server.Add = d.addRequest
+	server.Modify = d.modify
+	server.Del = d.del
+	server.ModifyDN = d.modifyDN
+}
+
+// add stores an entry with a random objectGUID and returns its ID, the string
+// form of the objectGUID. Users, computers and groups get an objectSid of the
+// domain, domains the domain SID. Unlike an add request the parent need not
+// exist.
+func (d *testDirectory) add(dn string, attributes map[string][]string) string {
+	parsed, err := parseDN(dn)
+	if err != nil {
+		panic(err)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	id, _ := formatGUID(d.insert(parsed, attributes).guid)
+	return id
+}
+
+// insert stores a new entry, completing the attributes like AD does.
+func (d *testDirectory) insert(dn *distinguishedName, attributes map[string][]string) *testEntry {
+	guid := make([]byte, 16)
+	rand.Read(guid)
+
+	entry := &testEntry{dn: dn, guid: guid}
+	objectClasses := testObjectClasses(attributes["objectClass"])
+	for name, values := range attributes {
+		if !strings.EqualFold(name, "objectClass") {
+			entry.set(name, values)
+		}
+	}
+	entry.set("objectClass", objectClasses)
+	entry.setRDN()
+	for _, objectClass := range objectClasses {
+		if objectClass == "computer" && entry.values("userAccountControl") == nil {
+			entry.set("userAccountControl", []string{"4096"})
+		}
+		if objectClass == "user" && entry.values("userAccountControl") == nil {
+			entry.set("userAccountControl", []string{"546"})
+		}
+		if objectClass == "group" && entry.values("groupType") == nil {
+			// a global security group, like AD creates by default
+			entry.set("groupType", []string{"-2147483646"})
+		}
+	}
+	if entry.values("nTSecurityDescriptor") == nil {
+		entry.set("nTSecurityDescriptor", []string{string(testSecurityDescriptor())})
+	}
+	entry.attributes = append(entry.attributes, &ldap.EntryAttribute{Name: "objectGUID", ByteValues: [][]byte{guid}})
+	if sid := d.sid(objectClasses); sid != nil {
+		entry.attributes = append(entry.attributes, &ldap.EntryAttribute{Name: "objectSid", ByteValues: [][]byte{sid}})
+	}
+
+	d.entries = append(d.entries, entry)
+	d.byGUID[hex.EncodeToString(guid)] = entry
+	return entry
+}
+
+// testObjectClasses completes the object classes of a new entry with the
+// classes they inherit from, so that e.g. computers are users as well.
+func testObjectClasses(objectClasses []string) []string {
+	hierarchy := map[string][]string{
+		"user":               {"top", "person", "organizationalPerson", "user"},
+		"computer":           {"top", "person", "organizationalPerson", "user", "computer"},
+		"group":              {"top", "group"},
+		"organizationalunit": {"top", "organizationalUnit"},
+	}
+	var result []string
+	seen := make(map[string]bool)
+	for _, objectClass := range objectClasses {
+		classes, ok := hierarchy[strings.ToLower(objectClass)]
+		if !ok {
+			classes = []string{objectClass}
+		}
+		for _, class := range classes {
+			if !seen[strings.ToLower(class)] {
+				seen[strings.ToLower(class)] = true
+				result = append(result, class)
+			}
+		}
+	}
+	return result
+}
+
+// addRequest handles an add request. The parent of the entry must exist.
+func (d *testDirectory) addRequest(request *ldap.AddRequest) uint16 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	dn, err := parseDN(request.DN)
+	if err != nil || len(dn.rdns) == 0 {
+		return ldap.LDAPResultInvalidDNSyntax
+	}
+	if d.find(dn) != nil {
+		return ldap.LDAPResultEntryAlreadyExists
+	}
+	if d.find(dn.Parent()) == nil {
+		return ldap.LDAPResultNoSuchObject
+	}
+
+	attributes := make(map[string][]string)
+	password := ""
+	for _, attribute := range request.Attributes {
+		switch {
+		case strings.EqualFold(attribute.Type, "unicodePwd"):
+			if len(attribute.Vals) != 1 {
+				return ldap.LDAPResultConstraintViolation
+			}
+			decoded, ok := decodeTestPassword(attribute.Vals[0])
+			if !ok {
+				return ldap.LDAPResultConstraintViolation
+			}
+			password = decoded
+		case strings.EqualFold(attribute.Type, "member"):
+			members, code := d.members(attribute.Vals)
+			if code != ldap.LDAPResultSuccess {
+				return code
+			}
+			attributes[attribute.Type] = members
+		default:
+			attributes[attribute.Type] = attribute.Vals
+		}
+	}
+
+	entry := d.insert(dn, attributes)
+	if password != "" {
+		entry.setPassword(password)
+	}
+	return ldap.LDAPResultSuccess
+}
+
+// modify handles a modify request. The changes are applied all or nothing.
+// unicodePwd can be replaced, or changed by deleting the current password
+// and adding the new one in the same request. Adding a password is allowed
+// as long as the entry has none.
+func (d *testDirectory) modify(request *ldap.ModifyRequest) uint16 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	entry, code := d.lookup(request.DN)
+	if code != ldap.LDAPResultSuccess {
+		return code
+	}
+
+	modified := &testEntry{dn: entry.dn, guid: entry.guid, password: entry.password}
+	for _, attribute := range entry.attributes {
+		copied := *attribute
+		modified.attributes = append(modified.attributes, &copied)
+	}
+
+	verified := false
+	for _, change := range request.Changes {
+		name := change.Modification.Type
+		values := change.Modification.Vals
+
+		if strings.EqualFold(name, "unicodePwd") {
+			if len(values) != 1 {
+				return ldap.LDAPResultConstraintViolation
+			}
+			password, ok := decodeTestPassword(values[0])
+			if !ok {
+				return ldap.LDAPResultConstraintViolation
+			}
+			switch change.Operation {
+			case ldap.DeleteAttribute:
+				if password != modified.password {
+					return ldap.LDAPResultConstraintViolation
+				}
+				verified = true
+			case ldap.AddAttribute:
+				if !verified && modified.password != "" {
+					return ldap.LDAPResultConstraintViolation
+				}
+				modified.setPassword(password)
+			case ldap.ReplaceAttribute:
+				modified.setPassword(password)
+			}
+			continue
+		}
+
+		for _, readOnly := range []string{"objectGUID", "objectSid", "distinguishedName", "memberOf"} {
+			if strings.EqualFold(name, readOnly) {
+				return ldap.LDAPResultUnwillingToPerform
+			}
+		}
+		if strings.EqualFold(name, modified.dn.rdns[0].Attributes[0].Type) || strings.EqualFold(name, "name") {
+			return ldap.LDAPResultNotAllowedOnRDN
+		}
+		if strings.EqualFold(name, "pwdLastSet") {
+			// only 0, expiring the password, or -1, the current time, can be set
+			if change.Operation != ldap.ReplaceAttribute || len(values) != 1 || values[0] != "0" && values[0] != "-1" {
+				return ldap.LDAPResultConstraintViolation
+			}
+			if values[0] == "-1" {
+				values = []string{testFileTime()}
+			}
+		}
+		if strings.EqualFold(name, "member") && len(values) > 0 {
+			values, code = d.members(values)
+			if code != ldap.LDAPResultSuccess {
+				return code
+			}
+		}
+
+		current := modified.strings(name)
+		switch change.Operation {
+		case ldap.AddAttribute:
+			for _, value := range values {
+				if testContains(current, value) {
+					return ldap.LDAPResultAttributeOrValueExists
+				}
+				current = append(current, value)
+			}
+		case ldap.DeleteAttribute:
+			if current == nil {
+				return ldap.LDAPResultNoSuchAttribute
+			}
+			if len(values) == 0 {
+				current = nil
+			}
+			for _, value := range values {
+				if !testContains(current, value) {
+					return ldap.LDAPResultNoSuchAttribute
+				}
+				current = testRemove(current, value)
+			}
+		case ldap.ReplaceAttribute:
+			current = nil
+			for _, value := range values {
+				if value != "" {
+					current = append(current, value)
+				}
+			}
+		}
+		modified.set(name, current)
+	}
+
+	entry.attributes = modified.attributes
+	entry.password = modified.password
+	return ldap.LDAPResultSuccess
+}
+
+// del handles a delete request. Only leaf entries can be deleted, the entry
+// is removed from the groups it was a member of.
+func (d *testDirectory) del(request *ldap.DelRequest) uint16 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	entry, code := d.lookup(request.DN)
+	if code != ldap.LDAPResultSuccess {
+		return code
+	}
+	for _, other := range d.entries {
+		if other != entry && testDescendant(other.dn, entry.dn) {
+			return ldap.LDAPResultNotAllowedOnNonLeaf
+		}
+	}
+
+	for i, other := range d.entries {
+		if other == entry {
+			d.entries = append(d.entries[:i], d.entries[i+1:]...)
+			break
+		}
+	}
+	delete(d.byGUID, hex.EncodeToString(entry.guid))
+	d.rewriteMembers(func(member *distinguishedName) *distinguishedName {
+		if member.Equal(entry.dn) {
+			return nil
+		}
+		return member
+	})
+	return ldap.LDAPResultSuccess
+}
+
+// modifyDN handles renaming and moving an entry along with its descendants.
+// Group memberships follow the moved entries.
+func (d *testDirectory) modifyDN(request *ldap.ModifyDNRequest) uint16 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	entry, code := d.lookup(request.DN)
+	if code != ldap.LDAPResultSuccess {
+		return code
+	}
+	rdn, err := parseDN(request.NewRDN)
+	if err != nil || len(rdn.rdns) != 1 {
+		return ldap.LDAPResultInvalidDNSyntax
+	}
+	parent := entry.dn.Parent()
+	if request.NewSuperior != "" {
+		superior, code := d.lookup(request.NewSuperior)
+		if code != ldap.LDAPResultSuccess {
+			return code
+		}
+		parent = superior.dn
+	}
+
+	oldDN := entry.dn
+	newDN := &distinguishedName{rdns: append([]*ldap.RelativeDN{rdn.rdns[0]}, parent.rdns...)}
+	if existing := d.find(newDN); existing != nil && existing != entry {
+		return ldap.LDAPResultEntryAlreadyExists
+	}
+	if testDescendant(parent, oldDN) {
+		return ldap.LDAPResultUnwillingToPerform
+	}
+
+	move := func(dn *distinguishedName) *distinguishedName {
+		if !testDescendant(dn, oldDN) {
+			return dn
+		}
+		prefix := dn.rdns[:len(dn.rdns)-len(oldDN.rdns)]
+		return &distinguishedName{rdns: append(append([]*ldap.RelativeDN{}, prefix...), newDN.rdns...)}
+	}
+	for _, other := range d.entries {
+		other.dn = move(other.dn)
+	}
+	entry.setRDN()
+	d.rewriteMembers(move)
+	return ldap.LDAPResultSuccess
+}
+
+// members resolves the DNs of new group members to the DNs of their entries.
+func (d *testDirectory) members(values []string) ([]string, uint16) {
+	var members []string
+	for _, value := range values {
+		entry, code := d.lookup(value)
+		if code != ldap.LDAPResultSuccess {
+			return nil, code
+		}
+		members = append(members, entry.dn.String())
+	}
+	return members, ldap.LDAPResultSuccess
+}
+
+// rewriteMembers replaces the member values of all groups by the result of
+// rewrite, dropping the values it returns nil for.
+func (d *testDirectory) rewriteMembers(rewrite func(*distinguishedName) *distinguishedName) {
+	for _, entry := range d.entries {
+		var members []string
+		for _, member := range entry.strings("member") {
+			dn, err := parseDN(member)
+			if err != nil {
+				continue
+			}
+			if dn = rewrite(dn); dn != nil {
+				members = append(members, dn.String())
+			}
+		}
+		if entry.values("member") != nil {
+			entry.set("member", members)
+		}
+	}
+}
+
+// memberOf returns the DNs of the groups the entry is a member of.
+func (d *testDirectory) memberOf(entry *testEntry) []*testEntry {
+	var groups []*testEntry
+	for _, group := range d.entries {
+		for _, member := range group.strings("member") {
+			if dn, err := parseDN(member); err == nil && dn.Equal(entry.dn) {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+	return groups
+}
+
+// find returns the entry with the given DN, or nil if there is none.
+func (d *testDirectory) find(dn *distinguishedName) *testEntry {
+	for _, entry := range d.entries {
+		if entry.dn.Equal(dn) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// password returns the current password of the entry with the given DN.
+func (d *testDirectory) password(dn string) string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	entry, code := d.lookup(dn)
+	if code != ldap.LDAPResultSuccess {
+		return ""
+	}
+	return entry.password
+}
+
+// attribute returns the values of an attribute of the entry with the given
+// DN, or nil if there is no such entry.
+func (d *testDirectory) attribute(dn string, name string) []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	entry, code := d.lookup(dn)
+	if code != ldap.LDAPResultSuccess {
+		return nil
+	}
+	return entry.strings(name)
+}
+
+// decodeTestPassword decodes a unicodePwd value, the password enclosed in
+// quotes and encoded as UTF-16LE.
+func decodeTestPassword(value string) (string, bool) {
+	if len(value)%2 != 0 {
+		return "", false
+	}
+	var units []uint16
+	for i := 0; i < len(value); i += 2 {
+		units = append(units, uint16(value[i])|uint16(value[i+1])<<8)
+	}
+	password := string(utf16.Decode(units))
+	if len(password) < 2 || !strings.HasPrefix(password, `"`) || !strings.HasSuffix(password, `"`) {
+		return "", false
+	}
+	return password[1 : len(password)-1], true
+}
+
+// sid returns a new objectSid for an entry of the given object classes, or
+// nil if entries of these classes have no SID.
+func (d *testDirectory) sid(objectClasses []string) []byte {
+	for _, objectClass := range objectClasses {
+		switch strings.ToLower(objectClass) {
+		case "domain":
+			sid, _ := parseSID(testDomainSID)
+			return sid
+		case "user", "computer", "group":
+			d.nextRID++
+			sid, _ := parseSID(fmt.Sprintf("%s-%d", testDomainSID, d.nextRID))
+			return sid
+		}
+	}
+	return nil
+}
+
+func (d *testDirectory) search(request *testSearchRequest) (uint16, []*ldap.Entry) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	base, code := d.lookup(request.BaseDN)
+	if code != ldap.LDAPResultSuccess {
+		return code, nil
+	}
+
+	var candidates []*testEntry
+	switch request.Scope {
+	case ldap.ScopeBaseObject:
+		candidates = []*testEntry{base}
+	case ldap.ScopeSingleLevel:
+		for _, entry := range d.entries {
+			if len(entry.dn.rdns) == len(base.dn.rdns)+1 && entry.dn.Parent().Equal(base.dn) {
+				candidates = append(candidates, entry)
+			}
+		}
+	default:
+		for _, entry := range d.entries {
+			if testDescendant(entry.dn, base.dn) {
+				candidates = append(candidates, entry)
+			}
+		}
+	}
+
+	extended := false
+	for _, control := range request.Controls {
+		extended = extended || control == (&ldapControlServerExtendDN{}).GetControlType()
+	}
+
+	var result []*ldap.Entry
+	for _, entry := range candidates {
+		if testMatchFilter(request.Filter, entry) {
+			result = append(result, d.result(entry, request.Attributes, extended))
+		}
+	}
+	return ldap.LDAPResultSuccess, result
+}
+
+// lookup resolves a base DN, which may also be given as <GUID=...> or
+// <SID=...>. An empty base DN is the root DSE.
+func (d *testDirectory) lookup(baseDN string) (*testEntry, uint16) {
+	if strings.HasPrefix(baseDN, "<GUID=") && strings.HasSuffix(baseDN, ">") {
+		id := baseDN[6 : len(baseDN)-1]
+		if guid, err := parseGUID(id); err == nil {
+			id = hex.EncodeToString(guid)
+		}
+		if entry, ok := d.byGUID[strings.ToLower(id)]; ok {
+			return entry, ldap.LDAPResultSuccess
+		}
+		return nil, ldap.LDAPResultNoSuchObject
+	}
+	if strings.HasPrefix(baseDN, "<SID=") && strings.HasSuffix(baseDN, ">") {
+		sid, err := parseSID(baseDN[5 : len(baseDN)-1])
+		if err != nil {
+			return nil, ldap.LDAPResultInvalidDNSyntax
+		}
+		for _, entry := range d.entries {
+			if values := entry.values("objectSid"); values != nil && bytes.Equal(values[0], sid) {
+				return entry, ldap.LDAPResultSuccess
+			}
+		}
+		return nil, ldap.LDAPResultNoSuchObject
+	}
+	dn, err := parseDN(baseDN)
+	if err != nil {
+		return nil, ldap.LDAPResultInvalidDNSyntax
+	}
+	if len(dn.rdns) == 0 {
+		return d.rootDSE(), ldap.LDAPResultSuccess
+	}
+	if entry := d.find(dn); entry != nil {
+		return entry, ldap.LDAPResultSuccess
+	}
+	return nil, ldap.LDAPResultNoSuchObject
+}
+
+// result returns the entry with the requested attributes as sent to clients,
+// including the memberOf back-links. The DNs of the entry, its members and
+// the groups it is a member of are extended DNs if asked for.
+func (d *testDirectory) result(e *testEntry, attributes []string, extended bool) *ldap.Entry {
+	formatDN := func(entry *testEntry) string {
+		if extended && entry.guid != nil {
+			return "<GUID=" + hex.EncodeToString(entry.guid) + ">;" + entry.dn.String()
+		}
+		return entry.dn.String()
+	}
+
+	all := e.attributes
+	if groups := d.memberOf(e); groups != nil {
+		memberOf := &ldap.EntryAttribute{Name: "memberOf"}
+		for _, group := range groups {
+			memberOf.Values = append(memberOf.Values, formatDN(group))
+		}
+		all = append(append([]*ldap.EntryAttribute{}, all...), memberOf)
+	}
+
+	result := &ldap.Entry{DN: formatDN(e)}
+	for _, attribute := range all {
+		for _, name := range attributes {
+			if name == "*" || strings.EqualFold(name, attribute.Name) {
+				if strings.EqualFold(attribute.Name, "member") {
+					members := &ldap.EntryAttribute{Name: attribute.Name}
+					for _, member := range attribute.Values {
+						if dn, err := parseDN(member); err == nil && d.find(dn) != nil {
+							member = formatDN(d.find(dn))
+						}
+						members.Values = append(members.Values, member)
+					}
+					attribute = members
+				}
+				result.Attributes = append(result.Attributes, attribute)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// strings returns the values of an attribute as strings.
+func (e *testEntry) strings(name string) []string {
+	var values []string
+	for _, value := range e.values(name) {
+		values = append(values, string(value))
+	}
+	return values
+}
+
+// set replaces the values of an attribute, removing it if there are none.
+func (e *testEntry) set(name string, values []string) {
+	for i, attribute := range e.attributes {
+		if strings.EqualFold(name, attribute.Name) {
+			e.attributes = append(e.attributes[:i], e.attributes[i+1:]...)
+			break
+		}
+	}
+	if len(values) > 0 {
+		e.attributes = append(e.attributes, &ldap.EntryAttribute{Name: name, Values: values})
+	}
+}
+
+// setRDN sets the naming attribute and the name to the value of the RDN.
+func (e *testEntry) setRDN() {
+	if len(e.dn.rdns) == 0 {
+		return
+	}
+	rdn := e.dn.rdns[0].Attributes[0]
+	e.set(rdn.Type, []string{rdn.Value})
+	e.set("name", []string{rdn.Value})
+}
+
+// setPassword stores a new password and, like AD, the time it was set.
+func (e *testEntry) setPassword(password string) {
+	e.password = password
+	e.set("pwdLastSet", []string{testFileTime()})
+}
+
+// testFileTime returns the current time as FILETIME, the 100ns intervals
+// since 1601.
+func testFileTime() string {
+	return strconv.FormatInt(time.Now().UnixNano()/100+116444736000000000, 10)
+}
+
+// testSecurityDescriptor returns the security descriptor of new entries, it
+// allows Everyone to read them.
+func testSecurityDescriptor() []byte {
+	everyone, _ := parseSID("S-1-1-0")
+	ace := make([]byte, 8, 8+len(everyone))
+	binary.LittleEndian.PutUint16(ace[2:4], uint16(8+len(everyone)))
+	binary.LittleEndian.PutUint32(ace[4:8], 0x20094)
+	ace = append(ace, everyone...)
+	sd := &securityDescriptor{aclRevision: 2, dacl: []*accessControlEntry{{raw: ace}}}
+	return sd.bytes()
+}
+
+func testContains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func testRemove(values []string, value string) []string {
+	var result []string
+	for _, v := range values {
+		if !strings.EqualFold(v, value) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func (e *testEntry) values(name string) [][]byte {
+	if strings.EqualFold(name, "distinguishedName") {
+		return [][]byte{[]byte(e.dn.String())}
+	}
+	for _, attribute := range e.attributes {
+		if !strings.EqualFold(name, attribute.Name) {
+			continue
+		}
+		if attribute.ByteValues != nil {
+			return attribute.ByteValues
+		}
+		var values [][]byte
+		for _, value := range attribute.Values {
+			values = append(values, []byte(value))
+		}
+		return values
+	}
+	return nil
+}
+
+// rootDSE returns the root DSE naming the first domain added as default
+// naming context.
+func (d *testDirectory) rootDSE() *testEntry {
+	root := &testEntry{
+		dn:         &distinguishedName{},
+		attributes: []*ldap.EntryAttribute{{Name: "objectClass", Values: []string{"top"}}},
+	}
+	for _, entry := range d.entries {
+		for _, objectClass := range entry.values("objectClass") {
+			if strings.EqualFold(string(objectClass), "domain") {
+				root.attributes = append(root.attributes, &ldap.EntryAttribute{Name: "defaultNamingContext", Values: []string{entry.dn.String()}})
+				return root
+			}
+		}
+	}
+	return root
+}
+
+func testDescendant(dn *distinguishedName, base *distinguishedName) bool {
+	if len(dn.rdns) < len(base.rdns) {
+		return false
+	}
+	return (&distinguishedName{rdns: dn.rdns[len(dn.rdns)-len(base.rdns):]}).Equal(base)
+}
+
+// testMatchFilter evaluates the and, or, not, equality and presence filters.
+// Strings compare case-insensitively, binary values byte by byte.
+func testMatchFilter(filter *ber.Packet, entry *testEntry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !testMatchFilter(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if testMatchFilter(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !testMatchFilter(filter.Children[0], entry)
+	case ldap.FilterPresent:
+		return entry.values(filter.Data.String()) != nil
+	case ldap.FilterEqualityMatch:
+		assertion := filter.Children[1].Data.Bytes()
+		for _, value := range entry.values(filter.Children[0].Data.String()) {
+			if bytes.Equal(value, assertion) ||
+				utf8.Valid(value) && utf8.Valid(assertion) && strings.EqualFold(string(value), string(assertion)) {
+				return true
+			}
+		}
+	}
+	return false
+}
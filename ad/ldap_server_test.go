@@ -0,0 +1,357 @@
+package ad
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// testLDAPServer is a minimal LDAP server listening on a local port which
+// answers just enough of the protocol to exercise the connection handling.
+type testLDAPServer struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+	binds    int32
+
+	connsLock sync.Mutex
+	conns     map[net.Conn]bool
+
+	// Bind returns the result code for a simple bind request. Binds always
+	// succeed if it is nil.
+	Bind func(name, password string) uint16
+
+	// StartTLS enables the StartTLS extended operation using these settings.
+	StartTLS *tls.Config
+
+	// SASL handles a step of a SASL bind and returns the result code and the
+	// server credentials. SASL binds are refused if it is nil.
+	SASL func(state *testSASLState, mechanism string, credentials []byte) (uint16, []byte)
+
+	// NTLM handles a step of an NTLM bind and returns the result code and the
+	// message sent in the matched DN. NTLM binds are refused if it is nil.
+	NTLM func(state *testSASLState, choice ber.Tag, message []byte) (uint16, []byte)
+
+	// Search returns the result code and the entries found for a search
+	// request. Searches are refused if it is nil.
+	Search func(request *testSearchRequest) (uint16, []*ldap.Entry)
+
+	// Add, Modify, Del and ModifyDN return the result code of the respective
+	// request. The requests are refused if they are nil.
+	Add      func(request *ldap.AddRequest) uint16
+	Modify   func(request *ldap.ModifyRequest) uint16
+	Del      func(request *ldap.DelRequest) uint16
+	ModifyDN func(request *ldap.ModifyDNRequest) uint16
+}
+
+// testSearchRequest is a decoded search request.
+type testSearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     *ber.Packet
+	Attributes []string
+	Controls   []string
+}
+
+// testSASLState keeps track of a SASL or NTLM bind on a single connection.
+// Setting Wrap and Unwrap enables a security layer once the bind succeeded.
+type testSASLState struct {
+	Step   int
+	Wrap   func([]byte) ([]byte, error)
+	Unwrap func([]byte) ([]byte, error)
+}
+
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+func newTestLDAPServer(t testing.TB) *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return startTestLDAPServer(listener)
+}
+
+// newTestLDAPServerTLS returns a server speaking LDAPS with the given settings.
+func newTestLDAPServerTLS(t testing.TB, config *tls.Config) *testLDAPServer {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return startTestLDAPServer(listener)
+}
+
+func startTestLDAPServer(listener net.Listener) *testLDAPServer {
+	s := &testLDAPServer{listener: listener, conns: make(map[net.Conn]bool)}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Addr returns the host:port the server listens on.
+func (s *testLDAPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Binds returns the number of bind requests received so far.
+func (s *testLDAPServer) Binds() int {
+	return int(atomic.LoadInt32(&s.binds))
+}
+
+// Disconnect closes all client connections, as a server dropping idle
+// connections would.
+func (s *testLDAPServer) Disconnect() {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *testLDAPServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *testLDAPServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testLDAPServer) handle(conn net.Conn) {
+	raw := conn
+	s.connsLock.Lock()
+	s.conns[raw] = true
+	s.connsLock.Unlock()
+	defer func() {
+		s.connsLock.Lock()
+		delete(s.conns, raw)
+		s.connsLock.Unlock()
+		raw.Close()
+	}()
+	sasl := &testSASLState{}
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			atomic.AddInt32(&s.binds, 1)
+			name := op.Children[1].Value.(string)
+			auth := op.Children[2]
+			if auth.Tag == sicilyNegotiate || auth.Tag == sicilyResponse {
+				if s.NTLM == nil {
+					s.respond(conn, messageID, ldap.ApplicationBindResponse, ldap.LDAPResultAuthMethodNotSupported)
+					continue
+				}
+				code, message := s.NTLM(sasl, auth.Tag, auth.Data.Bytes())
+				s.respondMatched(conn, messageID, ldap.ApplicationBindResponse, code, string(message))
+				if code == ldap.LDAPResultSuccess && sasl.Wrap != nil {
+					conn = &saslSecurityLayerConn{Conn: conn, wrap: sasl.Wrap, unwrap: sasl.Unwrap}
+				}
+				continue
+			}
+			if auth.Tag == 3 {
+				if s.SASL == nil {
+					s.respond(conn, messageID, ldap.ApplicationBindResponse, ldap.LDAPResultAuthMethodNotSupported)
+					continue
+				}
+				var credentials []byte
+				if len(auth.Children) > 1 {
+					credentials = auth.Children[1].Data.Bytes()
+				}
+				code, serverCredentials := s.SASL(sasl, auth.Children[0].Value.(string), credentials)
+				sasl.Step++
+				var extra []*ber.Packet
+				if serverCredentials != nil {
+					extra = append(extra, ber.NewString(ber.ClassContext, ber.TypePrimitive, 7, string(serverCredentials), "Server SASL Credentials"))
+				}
+				s.respond(conn, messageID, ldap.ApplicationBindResponse, code, extra...)
+				if code == ldap.LDAPResultSuccess && sasl.Wrap != nil {
+					conn = &saslSecurityLayerConn{Conn: conn, wrap: sasl.Wrap, unwrap: sasl.Unwrap}
+				}
+				continue
+			}
+			code := uint16(ldap.LDAPResultSuccess)
+			if s.Bind != nil {
+				code = s.Bind(name, auth.Data.String())
+			}
+			s.respond(conn, messageID, ldap.ApplicationBindResponse, code)
+		case ldap.ApplicationExtendedRequest:
+			if s.StartTLS == nil || op.Children[0].Data.String() != startTLSOID {
+				s.respond(conn, messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError)
+				continue
+			}
+			s.respond(conn, messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess)
+			tlsConn := tls.Server(conn, s.StartTLS)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationSearchRequest:
+			if s.Search == nil {
+				s.respond(conn, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultUnwillingToPerform)
+				continue
+			}
+			code, entries := s.Search(decodeTestSearchRequest(packet))
+			for _, entry := range entries {
+				s.respondEntry(conn, messageID, entry)
+			}
+			s.respond(conn, messageID, ldap.ApplicationSearchResultDone, code)
+		case ldap.ApplicationAddRequest:
+			s.respondUpdate(conn, messageID, ldap.ApplicationAddResponse, s.Add != nil, func() uint16 {
+				return s.Add(decodeTestAddRequest(op))
+			})
+		case ldap.ApplicationModifyRequest:
+			s.respondUpdate(conn, messageID, ldap.ApplicationModifyResponse, s.Modify != nil, func() uint16 {
+				return s.Modify(decodeTestModifyRequest(op))
+			})
+		case ldap.ApplicationDelRequest:
+			s.respondUpdate(conn, messageID, ldap.ApplicationDelResponse, s.Del != nil, func() uint16 {
+				return s.Del(&ldap.DelRequest{DN: op.Data.String()})
+			})
+		case ldap.ApplicationModifyDNRequest:
+			s.respondUpdate(conn, messageID, ldap.ApplicationModifyDNResponse, s.ModifyDN != nil, func() uint16 {
+				return s.ModifyDN(decodeTestModifyDNRequest(op))
+			})
+		default:
+			s.respond(conn, messageID, op.Tag+1, ldap.LDAPResultUnwillingToPerform)
+		}
+	}
+}
+
+func (s *testLDAPServer) respond(conn net.Conn, messageID int64, tag ber.Tag, code uint16, extra ...*ber.Packet) {
+	s.respondMatched(conn, messageID, tag, code, "", extra...)
+}
+
+func (s *testLDAPServer) respondMatched(conn net.Conn, messageID int64, tag ber.Tag, code uint16, matchedDN string, extra ...*ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, matchedDN, "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	for _, child := range extra {
+		response.AppendChild(child)
+	}
+	envelope.AppendChild(response)
+	conn.Write(envelope.Bytes())
+}
+
+// respondUpdate answers an update request with the result code of handle, or
+// refuses it if there is no handler.
+func (s *testLDAPServer) respondUpdate(conn net.Conn, messageID int64, tag ber.Tag, handled bool, handle func() uint16) {
+	if !handled {
+		s.respond(conn, messageID, tag, ldap.LDAPResultUnwillingToPerform)
+		return
+	}
+	s.respond(conn, messageID, tag, handle())
+}
+
+// respondEntry sends a SearchResultEntry. Byte values take precedence over
+// the string values of an attribute.
+func (s *testLDAPServer) respondEntry(conn net.Conn, messageID int64, entry *ldap.Entry) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "Object Name"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, attribute := range entry.Attributes {
+		partial := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		partial.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute.Name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		if attribute.ByteValues != nil {
+			for _, value := range attribute.ByteValues {
+				values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(value), "Value"))
+			}
+		} else {
+			for _, value := range attribute.Values {
+				values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+			}
+		}
+		partial.AppendChild(values)
+		attributes.AppendChild(partial)
+	}
+	response.AppendChild(attributes)
+	envelope.AppendChild(response)
+	conn.Write(envelope.Bytes())
+}
+
+func decodeTestSearchRequest(packet *ber.Packet) *testSearchRequest {
+	op := packet.Children[1]
+	request := &testSearchRequest{
+		BaseDN: op.Children[0].Data.String(),
+		Scope:  int(op.Children[1].Value.(int64)),
+		Filter: op.Children[6],
+	}
+	for _, attribute := range op.Children[7].Children {
+		request.Attributes = append(request.Attributes, attribute.Data.String())
+	}
+	if len(packet.Children) > 2 {
+		for _, control := range packet.Children[2].Children {
+			request.Controls = append(request.Controls, control.Children[0].Data.String())
+		}
+	}
+	return request
+}
+
+func decodeTestAddRequest(op *ber.Packet) *ldap.AddRequest {
+	request := &ldap.AddRequest{DN: op.Children[0].Data.String()}
+	for _, attribute := range op.Children[1].Children {
+		request.Attributes = append(request.Attributes, ldap.Attribute{
+			Type: attribute.Children[0].Data.String(),
+			Vals: decodeTestValues(attribute.Children[1]),
+		})
+	}
+	return request
+}
+
+func decodeTestModifyRequest(op *ber.Packet) *ldap.ModifyRequest {
+	request := &ldap.ModifyRequest{DN: op.Children[0].Data.String()}
+	for _, change := range op.Children[1].Children {
+		attribute := change.Children[1]
+		request.Changes = append(request.Changes, ldap.Change{
+			Operation: uint(change.Children[0].Value.(int64)),
+			Modification: ldap.PartialAttribute{
+				Type: attribute.Children[0].Data.String(),
+				Vals: decodeTestValues(attribute.Children[1]),
+			},
+		})
+	}
+	return request
+}
+
+func decodeTestModifyDNRequest(op *ber.Packet) *ldap.ModifyDNRequest {
+	request := &ldap.ModifyDNRequest{
+		DN:           op.Children[0].Data.String(),
+		NewRDN:       op.Children[1].Data.String(),
+		DeleteOldRDN: op.Children[2].Value.(bool),
+	}
+	if len(op.Children) > 3 {
+		request.NewSuperior = op.Children[3].Data.String()
+	}
+	return request
+}
+
+func decodeTestValues(set *ber.Packet) []string {
+	var values []string
+	for _, value := range set.Children {
+		values = append(values, value.Data.String())
+	}
+	return values
+}
@@ -1,10 +1,14 @@
 package ad
 
 import (
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 	"github.com/hashicorp/terraform/terraform"
+	"gopkg.in/ldap.v3"
 )
 
 func Provider() terraform.ResourceProvider {
@@ -19,11 +23,27 @@ func Provider() terraform.ResourceProvider {
 
 			"ip": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				Description: "The IP of the AD Server",
 				DefaultFunc: schema.EnvDefaultFunc("AD_IP", nil),
 			},
 
+			"servers": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Optional:    true,
+				Description: "A list of AD Servers to try in order if the previous one is not reachable",
+			},
+
+			"discover_servers": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Discover the AD Servers using the _ldap._tcp DNS SRV records of the domain",
+				DefaultFunc: schema.EnvDefaultFunc("AD_DISCOVER_SERVERS", false),
+			},
+
 			"user": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -33,25 +53,211 @@ func Provider() terraform.ResourceProvider {
 
 			"password": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				Sensitive:   true,
 				Description: "The user password of the AD Server",
 				DefaultFunc: schema.EnvDefaultFunc("AD_PASSWORD", nil),
 			},
 
+			"password_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "A file holding the user password, like a mounted secret",
+				DefaultFunc: schema.EnvDefaultFunc("AD_PASSWORD_FILE", nil),
+			},
+
+			"credential_process": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "A command printing the credentials as JSON, e.g. {\"Version\": 1, \"Password\": \"...\"}",
+				DefaultFunc: schema.EnvDefaultFunc("AD_CREDENTIAL_PROCESS", nil),
+			},
+
+			"bind_dn": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "The distinguished name used for the simple bind instead of the user",
+				DefaultFunc:   schema.EnvDefaultFunc("AD_BIND_DN", nil),
+				ValidateFunc:  validateDN,
+				ConflictsWith: []string{"bind_format"},
+			},
+
+			"bind_format": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "How the user is passed to the simple bind. Could be upn, downlevel, dn or verbatim",
+				ValidateFunc: validation.StringInSlice([]string{bindFormatUPN, bindFormatDownLevel, bindFormatDN, bindFormatVerbatim}, false),
+			},
+
+			"auth_method": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      authSimple,
+				Description:  "The method used to authenticate against the AD Server. Could be simple, kerberos or ntlm",
+				ValidateFunc: validation.StringInSlice([]string{authSimple, authKerberos, authNTLM}, false),
+			},
+
+			"krb5_realm": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The Kerberos realm of the user. Defaults to the upper-cased domain",
+				DefaultFunc: schema.EnvDefaultFunc("AD_KRB5_REALM", nil),
+			},
+
+			"krb5_conf": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to the krb5.conf to use. Defaults to KRB5_CONFIG or /etc/krb5.conf, the KDCs are looked up via DNS if neither exists",
+				DefaultFunc: schema.EnvDefaultFunc("AD_KRB5_CONF", nil),
+			},
+
+			"krb5_keytab": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "Path to a keytab holding the keys of the user",
+				DefaultFunc:   schema.EnvDefaultFunc("AD_KRB5_KEYTAB", nil),
+				ConflictsWith: []string{"krb5_ccache"},
+			},
+
+			"krb5_ccache": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "Path to a credential cache holding a ticket of the user",
+				DefaultFunc:   schema.EnvDefaultFunc("AD_KRB5_CCACHE", nil),
+				ConflictsWith: []string{"krb5_keytab"},
+			},
+
+			"krb5_spn": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The service principal of the AD Servers. Defaults to ldap/<server>",
+				DefaultFunc: schema.EnvDefaultFunc("AD_KRB5_SPN", nil),
+			},
+
 			"ssl": {
 				Type:        schema.TypeBool,
-				Required:    true,
+				Optional:    true,
 				Description: "Use an SSL connection to the AD",
 				DefaultFunc: schema.EnvDefaultFunc("AD_SSL", true),
+				Deprecated:  "Use transport instead",
+			},
+
+			"transport": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The transport used to connect to the AD Server. Could be plain, ldaps or starttls. Defaults to ldaps if ssl is set, plain otherwise",
+				DefaultFunc:  schema.EnvDefaultFunc("AD_TRANSPORT", nil),
+				ValidateFunc: validation.StringInSlice([]string{transportPlain, transportLDAPS, transportStartTLS}, false),
+			},
+
+			"ldap_port": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  "The port used by the plain and starttls transports. Defaults to 389, or the port of discovered servers",
+				ValidateFunc: validation.IntBetween(1, 65535),
+			},
+
+			"ldaps_port": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  "The port used by the ldaps transport. Defaults to 636",
+				ValidateFunc: validation.IntBetween(1, 65535),
+			},
+
+			"max_connections": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  "The maximum number of connections opened to the AD servers for parallel operations",
+				Default:      10,
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+
+			"request_timeout": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "60s",
+				Description:  "How long to wait for the answer to a single LDAP request",
+				ValidateFunc: validateDuration,
+			},
+
+			"retry_max_attempts": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      3,
+				Description:  "How often an operation failing with a retryable result code is attempted",
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+
+			"retry_backoff": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "1s",
+				Description:  "The wait before the first retry, doubled for each further one",
+				ValidateFunc: validateDuration,
+			},
+
+			"retry_max_backoff": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "30s",
+				Description:  "The longest wait between two retries",
+				ValidateFunc: validateDuration,
+			},
+
+			"retry_result_codes": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "The LDAP result codes of operations to retry. Defaults to busy (51) and unavailable (52)",
+				Elem: &schema.Schema{
+					Type:         schema.TypeInt,
+					ValidateFunc: validation.IntBetween(1, 255),
+				},
+			},
+
+			"ca_cert_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "Path to a PEM encoded CA certificate bundle used to verify the AD Server certificate",
+				DefaultFunc:   schema.EnvDefaultFunc("AD_CA_CERT_FILE", nil),
+				ConflictsWith: []string{"ca_cert"},
+			},
+
+			"ca_cert": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "PEM encoded CA certificate bundle used to verify the AD Server certificate",
+				DefaultFunc:   schema.EnvDefaultFunc("AD_CA_CERT", nil),
+				ConflictsWith: []string{"ca_cert_file"},
+			},
+
+			"tls_server_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The name the AD Server certificate is verified against. Defaults to the host connected to",
+				DefaultFunc: schema.EnvDefaultFunc("AD_TLS_SERVER_NAME", nil),
+			},
+
+			"tls_min_version": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "1.2",
+				Description:  "The minimum TLS version accepted from the AD Server. Could be 1.0, 1.1, 1.2 or 1.3",
+				ValidateFunc: validation.StringInSlice([]string{"1.0", "1.1", "1.2", "1.3"}, false),
+			},
+
+			"insecure_skip_verify": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Skip the verification of the AD Server certificate. Only use this for testing",
+				DefaultFunc: schema.EnvDefaultFunc("AD_INSECURE_SKIP_VERIFY", false),
 			},
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
-			"ad_computer": 				resourceComputer(),
-			"ad_group":    				resourceGroup(),
-			"ad_ou":       				resourceOrgUnit(),
-			"ad_user":     				resourceUser(),
+			"ad_computer":        resourceComputer(),
+			"ad_group":           resourceGroup(),
+			"ad_ou":              resourceOrgUnit(),
+			"ad_user":            resourceUser(),
 			"ad_user_attachment": resourceUserAttachment(),
 		},
 
@@ -69,16 +275,81 @@ func Provider() terraform.ResourceProvider {
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 
 	config := Config{
-		Domain:   d.Get("domain").(string),
-		IP:       d.Get("ip").(string),
-		Username: d.Get("user").(string),
-		Password: d.Get("password").(string),
-		UseSSL:   d.Get("ssl").(bool),
+		Domain:      d.Get("domain").(string),
+		IP:          d.Get("ip").(string),
+		Servers:     expandStringSlice(d.Get("servers").([]interface{})),
+		DiscoverSRV: d.Get("discover_servers").(bool),
+		Username:    d.Get("user").(string),
+		Password:    d.Get("password").(string),
+		BindDN:      d.Get("bind_dn").(string),
+		BindFormat:  d.Get("bind_format").(string),
+		UseSSL:      d.Get("ssl").(bool),
+		Transport:   d.Get("transport").(string),
+		LDAPPort:    d.Get("ldap_port").(int),
+		LDAPSPort:   d.Get("ldaps_port").(int),
+
+		PasswordFile:      d.Get("password_file").(string),
+		CredentialProcess: d.Get("credential_process").(string),
+
+		MaxConnections: d.Get("max_connections").(int),
+
+		RetryMaxAttempts: d.Get("retry_max_attempts").(int),
+		RetryResultCodes: expandIntSlice(d.Get("retry_result_codes").([]interface{})),
+
+		CACertFile:         d.Get("ca_cert_file").(string),
+		CACert:             d.Get("ca_cert").(string),
+		TLSServerName:      d.Get("tls_server_name").(string),
+		TLSMinVersion:      d.Get("tls_min_version").(string),
+		InsecureSkipVerify: d.Get("insecure_skip_verify").(bool),
+
+		AuthMethod: d.Get("auth_method").(string),
+		Kerberos: KerberosConfig{
+			Realm:    d.Get("krb5_realm").(string),
+			Krb5Conf: d.Get("krb5_conf").(string),
+			Keytab:   d.Get("krb5_keytab").(string),
+			CCache:   d.Get("krb5_ccache").(string),
+			SPN:      d.Get("krb5_spn").(string),
+		},
 	}
+	var err error
+	if config.RequestTimeout, err = time.ParseDuration(d.Get("request_timeout").(string)); err != nil {
+		return nil, err
+	}
+	if config.RetryBackoff, err = time.ParseDuration(d.Get("retry_backoff").(string)); err != nil {
+		return nil, err
+	}
+	if config.RetryMaxBackoff, err = time.ParseDuration(d.Get("retry_max_backoff").(string)); err != nil {
+		return nil, err
+	}
+
 	log.Printf("[DEBUG] Connecting to AD")
 	return config.Client()
 }
 
+// validateDN checks that a value is a valid distinguished name.
+func validateDN(v interface{}, k string) ([]string, []error) {
+	if _, err := ldap.ParseDN(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%q is not a valid distinguished name: %s", k, err)}
+	}
+	return nil, nil
+}
+
+// validateDuration checks that a value can be parsed by time.ParseDuration.
+func validateDuration(v interface{}, k string) ([]string, []error) {
+	if _, err := time.ParseDuration(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%q is not a valid duration: %s", k, err)}
+	}
+	return nil, nil
+}
+
+func expandIntSlice(configured []interface{}) []int {
+	vs := make([]int, 0, len(configured))
+	for _, v := range configured {
+		vs = append(vs, v.(int))
+	}
+	return vs
+}
+
 func expandStringSlice(configured []interface{}) []string {
 	vs := make([]string, 0, len(configured))
 	for _, v := range configured {
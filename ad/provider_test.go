@@ -1,10 +1,12 @@
 package ad
 
 import (
-	"github.com/hashicorp/terraform/helper/schema"
-	"github.com/hashicorp/terraform/terraform"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
 )
 
 var testAccProviders map[string]terraform.ResourceProvider
@@ -44,3 +46,100 @@ func testAccPreCheck(t *testing.T) {
 		t.Fatal("AD_PASSWORD must be set for acceptance tests")
 	}
 }
+
+// testResourceApply plans the configuration against the state and applies
+// the plan, like terraform apply does. A nil configuration destroys the
+// resource.
+func testResourceApply(t *testing.T, resource *schema.Resource, state *terraform.InstanceState, raw map[string]interface{}, meta interface{}) *terraform.InstanceState {
+	t.Helper()
+	diff := &terraform.InstanceDiff{Destroy: true}
+	if raw != nil {
+		var err error
+		diff, err = resource.Diff(state, terraform.NewResourceConfigRaw(raw), meta)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if diff == nil {
+			return state
+		}
+	}
+	state, err := resource.Apply(state, diff, meta)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return state
+}
+
+// testResourceRefresh reads the resource like terraform refresh does.
+func testResourceRefresh(t *testing.T, resource *schema.Resource, state *terraform.InstanceState, meta interface{}) *terraform.InstanceState {
+	t.Helper()
+	state, err := resource.RefreshWithoutUpgrade(state, meta)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return state
+}
+
+// testCheckAttributes fails the test unless the state holds the expected
+// attribute values.
+func testCheckAttributes(t *testing.T, state *terraform.InstanceState, expected map[string]string) {
+	t.Helper()
+	if state == nil {
+		t.Fatal("expected the resource to exist")
+	}
+	for key, value := range expected {
+		if state.Attributes[key] != value {
+			t.Errorf("expected %s to be %q, got %q", key, value, state.Attributes[key])
+		}
+	}
+}
+
+// testStateContains returns whether a list or set attribute of the state
+// holds the value.
+func testStateContains(attributes map[string]string, prefix string, value string) bool {
+	for key, v := range attributes {
+		if strings.HasPrefix(key, prefix) && key != prefix+"#" && v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// testResourceImport imports the object like terraform import does and reads
+// it into the state.
+func testResourceImport(t *testing.T, resource *schema.Resource, importID string, meta interface{}) *terraform.InstanceState {
+	t.Helper()
+	d := resource.Data(nil)
+	d.SetId(importID)
+	imported, err := resource.Importer.State(d, meta)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(imported) != 1 {
+		t.Fatalf("expected to import one object, got %d", len(imported))
+	}
+	return testResourceRefresh(t, resource, imported[0].State(), meta)
+}
+
+// testCheckEmptyPlan fails the test unless planning the configuration against
+// the state changes nothing. The ignored attributes are left out of the plan
+// like ignore_changes does.
+func testCheckEmptyPlan(t *testing.T, resource *schema.Resource, state *terraform.InstanceState, raw map[string]interface{}, meta interface{}, ignored ...string) {
+	t.Helper()
+	diff, err := resource.Diff(state, terraform.NewResourceConfigRaw(raw), meta)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if diff == nil {
+		return
+	}
+	for _, key := range ignored {
+		delete(diff.Attributes, key)
+	}
+	for key, attribute := range diff.Attributes {
+		t.Errorf("expected an empty plan, %s changes from %q to %q", key, attribute.Old, attribute.New)
+	}
+	if !diff.Empty() {
+		t.Fatalf("expected an empty plan, got %v", diff)
+	}
+}
@@ -3,18 +3,27 @@ package ad
 import (
 	"fmt"
 	"log"
-
-	ldap "gopkg.in/ldap.v3"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
 func resourceComputer() *schema.Resource {
-	return &schema.Resource{
+	resource := &schema.Resource{
 		Create: resourceADComputerCreate,
 		Read:   resourceADComputerRead,
 		Update: resourceADComputerUpdate,
 		Delete: resourceADComputerDelete,
+		Importer: &schema.ResourceImporter{
+			State: importADObject("Computer", true),
+		},
+		SchemaVersion: 1,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:        schema.TypeString,
@@ -30,18 +39,26 @@ func resourceComputer() *schema.Resource {
 				ForceNew:    false,
 			},
 			"parent": {
-				Type:        schema.TypeString,
-				Description: "The parent the computer belongs to. Could be either the DN of an OU or a DC.",
-				Required:    true,
-				ForceNew:    false,
+				Type:             schema.TypeString,
+				Description:      "The parent the computer belongs to. Could be either the DN of an OU or a DC.",
+				Required:         true,
+				DiffSuppressFunc: suppressEquivalentDN,
+				ForceNew:         false,
 			},
 			"dn": {
 				Type:        schema.TypeString,
 				Description: "The distinguished name of the computer",
 				Computed:    true,
 			},
+			"sid": {
+				Type:        schema.TypeString,
+				Description: "The security identifier (SID) of the computer",
+				Computed:    true,
+			},
 		},
 	}
+	resource.StateUpgraders = objectIDStateUpgraders(resource)
+	return resource
 }
 
 func resourceADComputerCreate(d *schema.ResourceData, meta interface{}) error {
@@ -49,44 +66,98 @@ func resourceADComputerCreate(d *schema.ResourceData, meta interface{}) error {
 	parent := d.Get("parent").(string)
 	description := d.Get("description").(string)
 
-	dnOfComputer := fmt.Sprintf("cn=%s,%s", computerName, parent)
+	dnOfComputer := childDN("cn", computerName, parent)
 
 	log.Printf("[DEBUG] Name of the DN is: %s", dnOfComputer)
 	log.Printf("[DEBUG] Adding the computer to the AD: %s", computerName)
 
-	client := meta.(*ldap.Conn)
+	session := pinClient(meta)
+	defer session.Close()
+	client := timeoutClient(d, session, schema.TimeoutCreate)
 
 	err := addComputerToAD(computerName, dnOfComputer, client, description)
 	if err != nil {
 		log.Printf("[ERROR] Error while adding a computer to the AD: %s ", err)
 		return fmt.Errorf("Error while adding a computer to the AD %s", err)
 	}
+	err = waitForEntry(client, dnOfComputer, d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		log.Printf("[ERROR] Error while waiting for the computer to become visible : %s", err)
+		return fmt.Errorf("Error while waiting for the computer to become visible %s", err)
+	}
 	log.Printf("[DEBUG] Computer added to AD successfully: %s", computerName)
 	d.Set("dn", dnOfComputer)
-	return resourceADComputerRead(d, meta)
+	return resourceADComputerRead(d, session)
 }
 
 func resourceADComputerUpdate(d *schema.ResourceData, meta interface{}) error {
-	//TODO
-	return resourceADComputerRead(d, meta)
-}
-
-func resourceADComputerDelete(d *schema.ResourceData, meta interface{}) error {
 	computerName := d.Get("name").(string)
 	parent := d.Get("parent").(string)
 
-	dnOfComputer := fmt.Sprintf("cn=%s,%s", computerName, parent)
+	var err error
+	session := pinClient(meta)
+	defer session.Close()
+	client := timeoutClient(d, session, schema.TimeoutUpdate)
+
+	dnOfComputer := currentDN(d)
+	origParent, _ := d.GetChange("parent")
+
+	if d.HasChange("name") {
+		// first: rename computer
+		log.Printf("[DEBUG] Name of the DN is: %s", dnOfComputer)
+		log.Printf("[DEBUG] About to rename the computer to %s", computerName)
+		err = renameADEntry(dnOfComputer, rdnString("cn", computerName), client)
+		if err == nil {
+			dnOfComputer = childDN("cn", computerName, origParent.(string))
+			// keep the account name in line, imports look computers up by it
+			err = updateADEntry(dnOfComputer, "sAMAccountName", computerAccountName(computerName), client)
+		}
+	}
+
+	if err == nil && d.HasChange("parent") {
+		// next: move computer to new parent
+		log.Printf("[DEBUG] Name of the DN is: %s", dnOfComputer)
+		log.Printf("[DEBUG] About to move the computer to %s", parent)
+		err = moveADEntry(dnOfComputer, rdnString("cn", computerName), parent, client)
+		if err == nil {
+			dnOfComputer = childDN("cn", computerName, parent)
+		}
+	}
 
 	log.Printf("[DEBUG] Name of the DN is: %s", dnOfComputer)
+
+	if err == nil && d.HasChange("description") {
+		new := d.Get("description").(string)
+		log.Printf("[DEBUG] found new description %s. Do update", new)
+		err = updateADEntry(dnOfComputer, "description", new, client)
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] Error while modifying a computer from AD: %s", err)
+		return fmt.Errorf("Error while modifying a computer from AD %s", err)
+	}
+
+	d.Set("dn", dnOfComputer)
+	return resourceADComputerRead(d, session)
+}
+
+func resourceADComputerDelete(d *schema.ResourceData, meta interface{}) error {
+	computerName := d.Get("name").(string)
+
 	log.Printf("[DEBUG] Deleting computer from the AD: %s", computerName)
 
-	resourceADComputerRead(d, meta)
+	session := pinClient(meta)
+	defer session.Close()
+	resourceADComputerRead(d, session)
 	if d.Id() == "" {
 		log.Printf("[DEBUG] Computer has been already removed from AD: %s", computerName)
 		return nil
 	}
 
-	client := meta.(*ldap.Conn)
+	dnOfComputer := currentDN(d)
+	log.Printf("[DEBUG] Name of the DN is: %s", dnOfComputer)
+
+	client := timeoutClient(d, session, schema.TimeoutDelete)
 
 	err := deleteComputerFromAD(dnOfComputer, client)
 	if err != nil {
@@ -98,66 +169,50 @@ func resourceADComputerDelete(d *schema.ResourceData, meta interface{}) error {
 }
 
 func resourceADComputerRead(d *schema.ResourceData, meta interface{}) error {
-	var computerName string
-	var parent string
-
+	computerName := d.Get("name").(string)
 	dnOfComputer := d.Get("dn").(string)
 
 	if dnOfComputer == "" {
-		computerName = d.Get("name").(string)
-		parent = d.Get("parent").(string)
-
-		dnOfComputer = fmt.Sprintf("cn=%s,%s", computerName, parent)
-	} else {
-		computerName, parent = parseDN(dnOfComputer, "cn")
+		dnOfComputer = childDN("cn", computerName, d.Get("parent").(string))
 	}
 
 	log.Printf("[DEBUG] Name of the DN is: %s", dnOfComputer)
 	log.Printf("[DEBUG] Refreshing the computer from the AD: %s", computerName)
 
-	client := meta.(*ldap.Conn)
-
-	searchParam := "(distinguishedName=" + dnOfComputer + ")"
-	_, searchBaseDN := parseDN(dnOfComputer, "cn")
-
-	if d.Id() != "" {
-		searchParam = "(objectGUID=" + generateObjectIdQueryString(d.Id()) + ")"
-		searchBaseDN = extractDomainFromDN(dnOfComputer)
-	}
+	client := timeoutClient(d, meta, schema.TimeoutRead)
 
-	log.Printf("[DEBUG] Search Parameters for computer: %s ", searchParam)
-
-	searchRequest := ldap.NewSearchRequest(
-		searchBaseDN, // The base dn to search
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(&(objectClass=Computer)"+searchParam+")", // The filter to apply
-		[]string{"dn", "cn", "description"},        // A list attributes to retrieve
-		nil,
-	)
-
-	searchRequest.Controls = append(searchRequest.Controls, &ldapControlServerExtendDN{})
-
-	sr, err := client.Search(searchRequest)
+	computer, err := getADEntry(d.Id(), dnOfComputer, filterEqual("objectClass", "Computer"), []string{"cn", "description", "objectGUID", "objectSid"}, client)
 	if err != nil {
 		log.Printf("[ERROR] Error while searching a computer: %s", err)
 		return fmt.Errorf("Error while searching a computer: %s", err)
 	}
-	if len(sr.Entries) == 0 {
+	if computer == nil {
 		log.Println("[ERROR] computer was not found")
 		d.SetId("")
-	} else {
-		if len(sr.Entries) > 1 {
-			log.Printf("[ERROR] Error found ambigious values for computer: %s", computerName)
-			return fmt.Errorf("Error found ambigious values for computer: %s", computerName)
-		}
-		computer := sr.Entries[0]
-		computerID, computerDN := parseExtendedDN(computer.DN)
-		computerName, parent = parseDN(computerDN, "cn")
-		d.SetId(computerID)
-		d.Set("dn", computerDN)
-		d.Set("name", computerName)
-		d.Set("description", computer.GetAttributeValue("description"))
-		d.Set("parent", parent)
+		return nil
+	}
+
+	_, computerDN := parseExtendedDN(computer.DN)
+	computerID, err := formatGUID(computer.GetRawAttributeValue("objectGUID"))
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the objectGUID of the computer: %s", err)
+		return fmt.Errorf("Error while reading the objectGUID of the computer: %s", err)
+	}
+	sid, err := formatSID(computer.GetRawAttributeValue("objectSid"))
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the objectSid of the computer: %s", err)
+		return fmt.Errorf("Error while reading the objectSid of the computer: %s", err)
+	}
+	dn, err := parseDN(computerDN)
+	if err != nil {
+		log.Printf("[ERROR] Error while parsing the DN of the computer: %s", err)
+		return fmt.Errorf("Error while parsing the DN of the computer: %s", err)
 	}
+	d.SetId(computerID)
+	d.Set("dn", computerDN)
+	d.Set("sid", sid)
+	d.Set("name", dn.Name())
+	d.Set("description", computer.GetAttributeValue("description"))
+	d.Set("parent", dn.Parent().String())
 	return nil
 }
@@ -0,0 +1,92 @@
+package ad
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResourceADComputer(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=Servers,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	directory.add("ou=Retired,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	resource := resourceComputer()
+
+	state := testResourceApply(t, resource, nil, map[string]interface{}{
+		"name":        "web01",
+		"parent":      "ou=Servers,dc=example,dc=com",
+		"description": "web server",
+	}, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"name":        "web01",
+		"parent":      "ou=Servers,dc=example,dc=com",
+		"dn":          "cn=web01,ou=Servers,dc=example,dc=com",
+		"description": "web server",
+	})
+	if _, err := parseGUID(state.ID); err != nil {
+		t.Fatalf("expected a GUID as ID, got %s", state.ID)
+	}
+	if !strings.HasPrefix(state.Attributes["sid"], testDomainSID+"-") {
+		t.Fatalf("expected a SID of the domain, got %s", state.Attributes["sid"])
+	}
+	if uac := directory.attribute("cn=web01,ou=Servers,dc=example,dc=com", "userAccountControl"); len(uac) != 1 || uac[0] != "4096" {
+		t.Fatalf("expected a workstation trust account, got %v", uac)
+	}
+	if name := directory.attribute("cn=web01,ou=Servers,dc=example,dc=com", "sAMAccountName"); len(name) != 1 || name[0] != "web01$" {
+		t.Fatalf("expected the account name web01$, got %v", name)
+	}
+
+	id := state.ID
+	state = testResourceApply(t, resource, state, map[string]interface{}{
+		"name":        "web02",
+		"parent":      "ou=Retired,dc=example,dc=com",
+		"description": "retired web server",
+	}, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"id":          id,
+		"name":        "web02",
+		"parent":      "ou=Retired,dc=example,dc=com",
+		"dn":          "cn=web02,ou=Retired,dc=example,dc=com",
+		"description": "retired web server",
+	})
+	if name := directory.attribute("cn=web02,ou=Retired,dc=example,dc=com", "sAMAccountName"); len(name) != 1 || name[0] != "web02$" {
+		t.Fatalf("expected the account name web02$, got %v", name)
+	}
+	if imported := testResourceImport(t, resource, "web02", conn); imported.ID != id {
+		t.Fatalf("expected to import the renamed computer %s, got %s", id, imported.ID)
+	}
+
+	state = testResourceRefresh(t, resource, state, conn)
+	testCheckAttributes(t, state, map[string]string{"id": id, "dn": "cn=web02,ou=Retired,dc=example,dc=com"})
+
+	testResourceApply(t, resource, state, nil, conn)
+	if directory.attribute("cn=web02,ou=Retired,dc=example,dc=com", "cn") != nil {
+		t.Fatal("expected the computer to be deleted")
+	}
+	if state := testResourceRefresh(t, resource, state, conn); state != nil {
+		t.Fatalf("expected the computer to be gone, got %v", state)
+	}
+}
+
+func TestResourceADComputer_import(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=Servers,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	directory.add("cn=web01,ou=Servers,dc=example,dc=com", map[string][]string{
+		"objectClass":    {"top", "user", "computer"},
+		"sAMAccountName": {"web01$"},
+		"description":    {"web server"},
+	})
+	resource := resourceComputer()
+
+	state := testResourceImport(t, resource, "web01", conn)
+	testCheckEmptyPlan(t, resource, state, map[string]interface{}{
+		"name":        "web01",
+		"parent":      "ou=Servers,dc=example,dc=com",
+		"description": "web server",
+	}, conn)
+}
@@ -3,18 +3,29 @@ package ad
 import (
 	"fmt"
 	"log"
-
-	ldap "gopkg.in/ldap.v3"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 )
 
 func resourceGroup() *schema.Resource {
-	return &schema.Resource{
+	resource := &schema.Resource{
 		Create: resourceADGroupCreate,
 		Read:   resourceADGroupRead,
 		Update: resourceADGroupUpdate,
 		Delete: resourceADGroupDelete,
+		Importer: &schema.ResourceImporter{
+			State: importADObject("group", true),
+		},
+		SchemaVersion: 2,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:        schema.TypeString,
@@ -30,17 +41,22 @@ func resourceGroup() *schema.Resource {
 				ForceNew:    false,
 			},
 			"parent": {
-				Type:        schema.TypeString,
-				Description: "The parent the group belongs to. Could be either the DN of an OU or a DC.",
-				Required:    true,
-				ForceNew:    false,
+				Type:             schema.TypeString,
+				Description:      "The parent the group belongs to. Could be either the DN of an OU or a DC.",
+				Required:         true,
+				DiffSuppressFunc: suppressEquivalentDN,
+				ForceNew:         false,
 			},
 			"type": {
-				Type:        schema.TypeString,
-				Description: "The type of the group. Could be either GLOBAL or LOCAL. Defaults to GLOBAL.",
-				Optional:    true,
-				Default:     "GLOBAL",
-				ForceNew:    true,
+				Type:         schema.TypeString,
+				Description:  "The type of the group. Could be GLOBAL, LOCAL, UNIVERSAL or one of them with a _DISTRIBUTION suffix for distribution groups, case-insensitive. Defaults to GLOBAL. Groups of other types, like builtin groups, have their groupType as type.",
+				Optional:     true,
+				Default:      "GLOBAL",
+				ForceNew:     true,
+				ValidateFunc: validation.StringInSlice(groupTypeNames(), true),
+				StateFunc: func(v interface{}) string {
+					return strings.ToUpper(v.(string))
+				},
 			},
 			"members": {
 				Type: schema.TypeSet,
@@ -56,8 +72,19 @@ func resourceGroup() *schema.Resource {
 				Description: "The distinguished name of the group",
 				Computed:    true,
 			},
+			"sid": {
+				Type:        schema.TypeString,
+				Description: "The security identifier (SID) of the group",
+				Computed:    true,
+			},
 		},
 	}
+	resource.StateUpgraders = append(objectIDStateUpgraders(resource), schema.StateUpgrader{
+		Version: 1,
+		Type:    resource.CoreConfigSchema().ImpliedType(),
+		Upgrade: upgradeGroupType,
+	})
+	return resource
 }
 
 func resourceADGroupCreate(d *schema.ResourceData, meta interface{}) error {
@@ -66,18 +93,25 @@ func resourceADGroupCreate(d *schema.ResourceData, meta interface{}) error {
 	description := d.Get("description").(string)
 	typeOfGroup := d.Get("type").(string)
 
-	dnOfGroup := fmt.Sprintf("cn=%s,%s", groupName, parent)
+	dnOfGroup := childDN("cn", groupName, parent)
 
 	log.Printf("[DEBUG] Name of the DN is : %s", dnOfGroup)
 	log.Printf("[DEBUG] Adding the group to the AD: %s ", groupName)
 
-	client := meta.(*ldap.Conn)
+	session := pinClient(meta)
+	defer session.Close()
+	client := timeoutClient(d, session, schema.TimeoutCreate)
 
 	err := addGroupToAD(groupName, dnOfGroup, typeOfGroup, client, description)
 	if err != nil {
 		log.Printf("[ERROR] Error while adding a group to the AD : %s", err)
 		return fmt.Errorf("Error while adding a group to the AD %s", err)
 	}
+	err = waitForEntry(client, dnOfGroup, d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		log.Printf("[ERROR] Error while waiting for the group to become visible : %s", err)
+		return fmt.Errorf("Error while waiting for the group to become visible %s", err)
+	}
 	log.Printf("[DEBUG] Group added to AD successfully: %s", groupName)
 	d.Set("dn", dnOfGroup)
 
@@ -90,50 +124,41 @@ func resourceADGroupCreate(d *schema.ResourceData, meta interface{}) error {
 		}
 	}
 
-	return resourceADGroupRead(d, meta)
+	return resourceADGroupRead(d, session)
 }
 
 func resourceADGroupUpdate(d *schema.ResourceData, meta interface{}) error {
 	groupName := d.Get("name").(string)
 	parent := d.Get("parent").(string)
 
-	var dnOfGroup string
 	var err error
-	client := meta.(*ldap.Conn)
-
-	if d.HasChange("parent") || d.HasChange("name") {
-		origName := groupName
-		origParent := parent
-
-		if d.HasChange("parent") {
-			o, _ := d.GetChange("parent")
-			origParent = o.(string)
-		}
-
-		if d.HasChange("name") {
-			o, _ := d.GetChange("name")
-			origName = o.(string)
-		}
-
-		if err == nil && origName != groupName {
-			// first: rename group
-			dnOfGroup = fmt.Sprintf("cn=%s,%s", origName, origParent)
-			log.Printf("[DEBUG] Name of the DN is : %s", dnOfGroup)
-			log.Printf("[DEBUG] About to rename the group to %s", groupName)
-			err = renameADEntry(dnOfGroup, fmt.Sprintf("cn=%s", groupName), client)
+	session := pinClient(meta)
+	defer session.Close()
+	client := timeoutClient(d, session, schema.TimeoutUpdate)
+
+	dnOfGroup := currentDN(d)
+	origParent, _ := d.GetChange("parent")
+
+	if d.HasChange("name") {
+		// first: rename group
+		log.Printf("[DEBUG] Name of the DN is : %s", dnOfGroup)
+		log.Printf("[DEBUG] About to rename the group to %s", groupName)
+		err = renameADEntry(dnOfGroup, rdnString("cn", groupName), client)
+		if err == nil {
+			dnOfGroup = childDN("cn", groupName, origParent.(string))
 		}
+	}
 
-		if err == nil && origParent != parent {
-			// next: move group to new parent
-			dnOfGroup = fmt.Sprintf("cn=%s,%s", groupName, origParent)
-			log.Printf("[DEBUG] Name of the DN is : %s", dnOfGroup)
-			log.Printf("[DEBUG] About to move the group to %s", parent)
-			err = moveADEntry(dnOfGroup, fmt.Sprintf("cn=%s", groupName), parent, client)
+	if err == nil && d.HasChange("parent") {
+		// next: move group to new parent
+		log.Printf("[DEBUG] Name of the DN is : %s", dnOfGroup)
+		log.Printf("[DEBUG] About to move the group to %s", parent)
+		err = moveADEntry(dnOfGroup, rdnString("cn", groupName), parent, client)
+		if err == nil {
+			dnOfGroup = childDN("cn", groupName, parent)
 		}
 	}
 
-	dnOfGroup = fmt.Sprintf("cn=%s,%s", groupName, parent)
-
 	log.Printf("[DEBUG] Name of the DN is : %s", dnOfGroup)
 
 	if err == nil && d.HasChange("description") {
@@ -170,25 +195,26 @@ func resourceADGroupUpdate(d *schema.ResourceData, meta interface{}) error {
 	}
 
 	d.Set("dn", dnOfGroup)
-	return resourceADGroupRead(d, meta)
+	return resourceADGroupRead(d, session)
 }
 
 func resourceADGroupDelete(d *schema.ResourceData, meta interface{}) error {
 	groupName := d.Get("name").(string)
-	parent := d.Get("parent").(string)
 
-	dnOfGroup := fmt.Sprintf("cn=%s,%s", groupName, parent)
-
-	log.Printf("[DEBUG] Name of the DN is : %s", dnOfGroup)
 	log.Printf("[DEBUG] Deleting the group from the AD : %s", groupName)
 
-	resourceADGroupRead(d, meta)
+	session := pinClient(meta)
+	defer session.Close()
+	resourceADGroupRead(d, session)
 	if d.Id() == "" {
 		log.Printf("[DEBUG] Group has been already removed from AD: %s", groupName)
 		return nil
 	}
 
-	client := meta.(*ldap.Conn)
+	dnOfGroup := currentDN(d)
+	log.Printf("[DEBUG] Name of the DN is : %s", dnOfGroup)
+
+	client := timeoutClient(d, session, schema.TimeoutDelete)
 
 	err := deleteGroupFromAD(dnOfGroup, client)
 	if err != nil {
@@ -200,66 +226,80 @@ func resourceADGroupDelete(d *schema.ResourceData, meta interface{}) error {
 }
 
 func resourceADGroupRead(d *schema.ResourceData, meta interface{}) error {
-	var groupName string
-	var parent string
-
+	groupName := d.Get("name").(string)
 	dnOfGroup := d.Get("dn").(string)
 
 	if dnOfGroup == "" {
-		groupName = d.Get("name").(string)
-		parent = d.Get("parent").(string)
-
-		dnOfGroup = fmt.Sprintf("cn=%s,%s", groupName, parent)
-	} else {
-		groupName, parent = parseDN(dnOfGroup, "cn")
+		dnOfGroup = childDN("cn", groupName, d.Get("parent").(string))
 	}
 
 	log.Printf("[DEBUG] Name of the DN is : %s ", dnOfGroup)
 	log.Printf("[DEBUG] Searching the group from the AD : %s ", groupName)
 
-	client := meta.(*ldap.Conn)
-
-	searchParam := "(distinguishedName=" + dnOfGroup + ")"
-	_, searchBaseDN := parseDN(dnOfGroup, "cn")
-
-	if d.Id() != "" {
-		searchParam = "(objectGUID=" + generateObjectIdQueryString(d.Id()) + ")"
-		searchBaseDN = extractDomainFromDN(dnOfGroup)
-	}
+	client := timeoutClient(d, meta, schema.TimeoutRead)
 
-	log.Printf("[DEBUG] Search Parameters for group: %s ", searchParam)
-
-	searchRequest := ldap.NewSearchRequest(
-		searchBaseDN, // The base dn to search
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(&(objectClass=group)"+searchParam+")", // The filter to apply
-		[]string{"dn", "cn", "description"},     // A list attributes to retrieve
-		nil,
-	)
-
-	searchRequest.Controls = append(searchRequest.Controls, &ldapControlServerExtendDN{})
-
-	sr, err := client.Search(searchRequest)
+	group, err := getADEntry(d.Id(), dnOfGroup, filterEqual("objectClass", "group"), []string{"cn", "description", "groupType", "member", "objectGUID", "objectSid"}, client)
 	if err != nil {
 		log.Printf("[ERROR] Error while searching a group: %s", err)
 		return fmt.Errorf("Error while searching a group: %s", err)
 	}
-	if len(sr.Entries) == 0 {
+	if group == nil {
 		log.Println("[ERROR] Group was not found")
 		d.SetId("")
-	} else {
-		if len(sr.Entries) > 1 {
-			log.Printf("[ERROR] Error found ambigious values for group: %s", groupName)
-			return fmt.Errorf("Error found ambigious values for group: %s", groupName)
-		}
-		group := sr.Entries[0]
-		groupID, groupDN := parseExtendedDN(group.DN)
-		groupName, parent = parseDN(groupDN, "cn")
-		d.SetId(groupID)
-		d.Set("dn", groupDN)
-		d.Set("name", groupName)
-		d.Set("description", group.GetAttributeValue("description"))
-		d.Set("parent", parent)
+		return nil
+	}
+
+	_, groupDN := parseExtendedDN(group.DN)
+	groupID, err := formatGUID(group.GetRawAttributeValue("objectGUID"))
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the objectGUID of the group: %s", err)
+		return fmt.Errorf("Error while reading the objectGUID of the group: %s", err)
+	}
+	sid, err := formatSID(group.GetRawAttributeValue("objectSid"))
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the objectSid of the group: %s", err)
+		return fmt.Errorf("Error while reading the objectSid of the group: %s", err)
 	}
+	dn, err := parseDN(groupDN)
+	if err != nil {
+		log.Printf("[ERROR] Error while parsing the DN of the group: %s", err)
+		return fmt.Errorf("Error while parsing the DN of the group: %s", err)
+	}
+	typeOfGroup, err := groupTypeName(group.GetAttributeValue("groupType"))
+	if err != nil {
+		// builtin groups among others cannot be created by the resource but
+		// are still read, with their groupType as type
+		log.Printf("[WARN] Reading the group %s with its groupType as type: %s", groupDN, err)
+		typeOfGroup = group.GetAttributeValue("groupType")
+	}
+	members := groupMembers(group.GetAttributeValues("member"), d.Get("members").(*schema.Set).List())
+	d.SetId(groupID)
+	d.Set("dn", groupDN)
+	d.Set("sid", sid)
+	d.Set("name", dn.Name())
+	d.Set("description", group.GetAttributeValue("description"))
+	d.Set("parent", dn.Parent().String())
+	d.Set("type", typeOfGroup)
+	d.Set("members", members)
 	return nil
 }
+
+// groupMembers returns the DNs of the members of a group. Members which are
+// known by an equivalent DN, e.g. one differing in case, keep it to not cause
+// a diff.
+func groupMembers(values []string, known []interface{}) []string {
+	members := make([]string, 0, len(values))
+	for _, value := range values {
+		_, member := parseExtendedDN(value)
+		if memberDN, err := parseDN(member); err == nil {
+			for _, k := range known {
+				if knownDN, err := parseDN(k.(string)); err == nil && knownDN.Equal(memberDN) {
+					member = k.(string)
+					break
+				}
+			}
+		}
+		members = append(members, member)
+	}
+	return members
+}
@@ -0,0 +1,154 @@
+package ad
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestResourceADGroup(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=Groups,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	userID := directory.add("cn=Jane Doe,dc=example,dc=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"jdoe"}})
+	directory.add("cn=web01,dc=example,dc=com", map[string][]string{"objectClass": {"computer"}, "sAMAccountName": {"web01$"}})
+	resource := resourceGroup()
+
+	state := testResourceApply(t, resource, nil, map[string]interface{}{
+		"name":    "Web Admins",
+		"parent":  "dc=example,dc=com",
+		"type":    "LOCAL",
+		"members": []interface{}{"cn=Jane Doe,dc=example,dc=com"},
+	}, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"name":   "Web Admins",
+		"parent": "dc=example,dc=com",
+		"dn":     "cn=Web Admins,dc=example,dc=com",
+		"type":   "LOCAL",
+	})
+	if groupType := directory.attribute("cn=Web Admins,dc=example,dc=com", "groupType"); !reflect.DeepEqual(groupType, []string{"-2147483644"}) {
+		t.Fatalf("expected a domain local group, got %v", groupType)
+	}
+	testCheckMembers(t, directory, "cn=Web Admins,dc=example,dc=com", "cn=Jane Doe,dc=example,dc=com")
+
+	// the members are read back through the memberOf back-link of the user
+	user := testResourceRefresh(t, resourceUser(), &terraform.InstanceState{ID: userID}, conn)
+	testCheckAttributes(t, user, map[string]string{"groups.#": "1"})
+	if groups := user.Attributes; !testStateContains(groups, "groups.", "cn=Web Admins,dc=example,dc=com") {
+		t.Fatalf("expected the user to be a member of the group, got %v", groups)
+	}
+
+	id := state.ID
+	state = testResourceApply(t, resource, state, map[string]interface{}{
+		"name":        "Web Operators",
+		"parent":      "ou=Groups,dc=example,dc=com",
+		"type":        "LOCAL",
+		"description": "operates the web servers",
+		"members":     []interface{}{"cn=web01,dc=example,dc=com"},
+	}, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"id":          id,
+		"name":        "Web Operators",
+		"parent":      "ou=Groups,dc=example,dc=com",
+		"dn":          "cn=Web Operators,ou=Groups,dc=example,dc=com",
+		"description": "operates the web servers",
+	})
+	testCheckMembers(t, directory, "cn=Web Operators,ou=Groups,dc=example,dc=com", "cn=web01,dc=example,dc=com")
+
+	testResourceApply(t, resource, state, nil, conn)
+	if state := testResourceRefresh(t, resource, state, conn); state != nil {
+		t.Fatalf("expected the group to be gone, got %v", state)
+	}
+}
+
+// testCheckMembers fails the test unless the group has exactly the expected
+// members.
+func testCheckMembers(t *testing.T, directory *testDirectory, groupDN string, expected ...string) {
+	t.Helper()
+	members := directory.attribute(groupDN, "member")
+	sort.Strings(members)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(members, expected) {
+		t.Fatalf("expected the members %v, got %v", expected, members)
+	}
+}
+
+func TestResourceADGroup_import(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("cn=Jane Doe,dc=example,dc=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"jdoe"}})
+	directory.add("cn=web01,dc=example,dc=com", map[string][]string{"objectClass": {"computer"}, "sAMAccountName": {"web01$"}})
+	resource := resourceGroup()
+
+	for groupType, name := range map[string]string{
+		"-2147483646": "GLOBAL",
+		"-2147483644": "LOCAL",
+		"-2147483640": "UNIVERSAL",
+		"2":           "GLOBAL_DISTRIBUTION",
+		"4":           "LOCAL_DISTRIBUTION",
+		"8":           "UNIVERSAL_DISTRIBUTION",
+	} {
+		dn := "cn=" + name + ",dc=example,dc=com"
+		directory.add(dn, map[string][]string{
+			"objectClass":    {"group"},
+			"sAMAccountName": {name},
+			"groupType":      {groupType},
+			"description":    {"web admins"},
+			"member":         {"CN=Jane Doe,DC=example,DC=com", "cn=web01,dc=example,dc=com"},
+		})
+
+		state := testResourceImport(t, resource, name, conn)
+		testCheckAttributes(t, state, map[string]string{"type": name, "members.#": "2"})
+		testCheckEmptyPlan(t, resource, state, map[string]interface{}{
+			"name":        name,
+			"parent":      "dc=example,dc=com",
+			"type":        name,
+			"description": "web admins",
+			"members":     []interface{}{"cn=Jane Doe,dc=example,dc=com", "cn=web01,dc=example,dc=com"},
+		}, conn)
+		// types are case-insensitive
+		testCheckEmptyPlan(t, resource, state, map[string]interface{}{
+			"name":        name,
+			"parent":      "dc=example,dc=com",
+			"type":        strings.ToLower(name),
+			"description": "web admins",
+			"members":     []interface{}{"cn=Jane Doe,dc=example,dc=com", "cn=web01,dc=example,dc=com"},
+		}, conn)
+	}
+
+	// groups of other types are read with their groupType instead of being
+	// planned as global groups
+	directory.add("cn=Administrators,dc=example,dc=com", map[string][]string{
+		"objectClass":    {"group"},
+		"sAMAccountName": {"Administrators"},
+		"groupType":      {"-2147483643"},
+	})
+	state := testResourceImport(t, resource, "Administrators", conn)
+	testCheckAttributes(t, state, map[string]string{"name": "Administrators", "type": "-2147483643"})
+}
+
+func TestUpgradeGroupType(t *testing.T) {
+	for old, upgraded := range map[string]string{
+		"LOCAL":     "LOCAL",
+		"UNIVERSAL": "UNIVERSAL",
+		"local":     "GLOBAL",
+		"global":    "GLOBAL",
+		"security":  "GLOBAL",
+		"":          "GLOBAL",
+	} {
+		state, err := upgradeGroupType(map[string]interface{}{"id": "04030201-0605-0807-090a-0b0c0d0e0f10", "type": old}, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if state["type"] != upgraded {
+			t.Fatalf("expected the type %q to be upgraded to %s, got %v", old, upgraded, state["type"])
+		}
+	}
+}
@@ -3,18 +3,27 @@ package ad
 import (
 	"fmt"
 	"log"
-
-	ldap "gopkg.in/ldap.v3"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
 func resourceOrgUnit() *schema.Resource {
-	return &schema.Resource{
+	resource := &schema.Resource{
 		Create: resourceADOrgUnitCreate,
 		Read:   resourceADOrgUnitRead,
 		Update: resourceADOrgUnitUpdate,
 		Delete: resourceADOrgUnitDelete,
+		Importer: &schema.ResourceImporter{
+			State: importADObject("organizationalunit", false),
+		},
+		SchemaVersion: 1,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:        schema.TypeString,
@@ -30,10 +39,11 @@ func resourceOrgUnit() *schema.Resource {
 				ForceNew:    false,
 			},
 			"parent": {
-				Type:        schema.TypeString,
-				Description: "The parent the organizational unit belongs to. Could be either the DN of an OU or a DC.",
-				Required:    true,
-				ForceNew:    false,
+				Type:             schema.TypeString,
+				Description:      "The parent the organizational unit belongs to. Could be either the DN of an OU or a DC.",
+				Required:         true,
+				DiffSuppressFunc: suppressEquivalentDN,
+				ForceNew:         false,
 			},
 			"dn": {
 				Type:        schema.TypeString,
@@ -42,6 +52,8 @@ func resourceOrgUnit() *schema.Resource {
 			},
 		},
 	}
+	resource.StateUpgraders = objectIDStateUpgraders(resource)
+	return resource
 }
 
 func resourceADOrgUnitCreate(d *schema.ResourceData, meta interface{}) error {
@@ -49,64 +61,62 @@ func resourceADOrgUnitCreate(d *schema.ResourceData, meta interface{}) error {
 	parent := d.Get("parent").(string)
 	description := d.Get("description").(string)
 
-	dnOfOrgUnit := fmt.Sprintf("ou=%s,%s", orgUnitName, parent)
+	dnOfOrgUnit := childDN("ou", orgUnitName, parent)
 
 	log.Printf("[DEBUG] Name of the DN is : %s", dnOfOrgUnit)
 	log.Printf("[DEBUG] Adding the organizational unit to the AD: %s ", orgUnitName)
 
-	client := meta.(*ldap.Conn)
+	session := pinClient(meta)
+	defer session.Close()
+	client := timeoutClient(d, session, schema.TimeoutCreate)
 
 	err := addOrgUnitToAD(orgUnitName, dnOfOrgUnit, client, description)
 	if err != nil {
 		log.Printf("[ERROR] Error while adding a organizational unit to the AD : %s", err)
 		return fmt.Errorf("Error while adding a organizational unit to the AD %s", err)
 	}
+	err = waitForEntry(client, dnOfOrgUnit, d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		log.Printf("[ERROR] Error while waiting for the organizational unit to become visible : %s", err)
+		return fmt.Errorf("Error while waiting for the organizational unit to become visible %s", err)
+	}
 	log.Printf("[DEBUG] Organizational Unit added to AD successfully: %s", orgUnitName)
 	d.Set("dn", dnOfOrgUnit)
-	return resourceADOrgUnitRead(d, meta)
+	return resourceADOrgUnitRead(d, session)
 }
 
 func resourceADOrgUnitUpdate(d *schema.ResourceData, meta interface{}) error {
 	orgUnitName := d.Get("name").(string)
 	parent := d.Get("parent").(string)
 
-	var dnOfOrgUnit string
 	var err error
-	client := meta.(*ldap.Conn)
-
-	if d.HasChange("parent") || d.HasChange("name") {
-		origName := orgUnitName
-		origParent := parent
-
-		if d.HasChange("parent") {
-			o, _ := d.GetChange("parent")
-			origParent = o.(string)
-		}
-
-		if d.HasChange("name") {
-			o, _ := d.GetChange("name")
-			origName = o.(string)
-		}
-
-		if err == nil && origName != orgUnitName {
-			// first: rename orgunit
-			dnOfOrgUnit = fmt.Sprintf("ou=%s,%s", origName, origParent)
-			log.Printf("[DEBUG] Name of the DN is : %s", dnOfOrgUnit)
-			log.Printf("[DEBUG] About to rename the organizational unit to %s", orgUnitName)
-			err = renameADEntry(dnOfOrgUnit, fmt.Sprintf("ou=%s", orgUnitName), client)
+	session := pinClient(meta)
+	defer session.Close()
+	client := timeoutClient(d, session, schema.TimeoutUpdate)
+
+	dnOfOrgUnit := currentDN(d)
+	origParent, _ := d.GetChange("parent")
+
+	if d.HasChange("name") {
+		// first: rename orgunit
+		log.Printf("[DEBUG] Name of the DN is : %s", dnOfOrgUnit)
+		log.Printf("[DEBUG] About to rename the organizational unit to %s", orgUnitName)
+		err = renameADEntry(dnOfOrgUnit, rdnString("ou", orgUnitName), client)
+		if err == nil {
+			dnOfOrgUnit = childDN("ou", orgUnitName, origParent.(string))
 		}
+	}
 
-		if err == nil && origParent != parent {
-			// next: move group to new parent
-			dnOfOrgUnit = fmt.Sprintf("ou=%s,%s", orgUnitName, origParent)
-			log.Printf("[DEBUG] Name of the DN is : %s", dnOfOrgUnit)
-			log.Printf("[DEBUG] About to move the organizational unit to %s", parent)
-			err = moveADEntry(dnOfOrgUnit, fmt.Sprintf("cn=%s", orgUnitName), parent, client)
+	if err == nil && d.HasChange("parent") {
+		// next: move group to new parent
+		log.Printf("[DEBUG] Name of the DN is : %s", dnOfOrgUnit)
+		log.Printf("[DEBUG] About to move the organizational unit to %s", parent)
+		err = moveADEntry(dnOfOrgUnit, rdnString("ou", orgUnitName), parent, client)
+		if err == nil {
+			dnOfOrgUnit = childDN("ou", orgUnitName, parent)
 		}
 	}
 
-	dnOfOrgUnit = fmt.Sprintf("ou=%s,%s", orgUnitName, parent)
-
 	log.Printf("[DEBUG] Name of the DN is : %s", dnOfOrgUnit)
 
 	if err == nil && d.HasChange("description") {
@@ -121,25 +131,26 @@ func resourceADOrgUnitUpdate(d *schema.ResourceData, meta interface{}) error {
 	}
 
 	d.Set("dn", dnOfOrgUnit)
-	return resourceADOrgUnitRead(d, meta)
+	return resourceADOrgUnitRead(d, session)
 }
 
 func resourceADOrgUnitDelete(d *schema.ResourceData, meta interface{}) error {
 	orgUnitName := d.Get("name").(string)
-	parent := d.Get("parent").(string)
 
-	dnOfOrgUnit := fmt.Sprintf("ou=%s,%s", orgUnitName, parent)
-
-	log.Printf("[DEBUG] Name of the DN is : %s", dnOfOrgUnit)
 	log.Printf("[DEBUG] Deleting the organizational unit from the AD : %s", orgUnitName)
 
-	resourceADOrgUnitRead(d, meta)
+	session := pinClient(meta)
+	defer session.Close()
+	resourceADOrgUnitRead(d, session)
 	if d.Id() == "" {
 		log.Printf("[DEBUG] Organizational Unit has been already removed from AD: %s", orgUnitName)
 		return nil
 	}
 
-	client := meta.(*ldap.Conn)
+	dnOfOrgUnit := currentDN(d)
+	log.Printf("[DEBUG] Name of the DN is : %s", dnOfOrgUnit)
+
+	client := timeoutClient(d, session, schema.TimeoutDelete)
 
 	err := deleteOrgUnitFromAD(dnOfOrgUnit, client)
 	if err != nil {
@@ -151,66 +162,44 @@ func resourceADOrgUnitDelete(d *schema.ResourceData, meta interface{}) error {
 }
 
 func resourceADOrgUnitRead(d *schema.ResourceData, meta interface{}) error {
-	var orgUnitName string
-	var parent string
-
+	orgUnitName := d.Get("name").(string)
 	dnOfOrgUnit := d.Get("dn").(string)
 
 	if dnOfOrgUnit == "" {
-		orgUnitName = d.Get("name").(string)
-		parent = d.Get("parent").(string)
-
-		dnOfOrgUnit = fmt.Sprintf("ou=%s,%s", orgUnitName, parent)
-	} else {
-		orgUnitName, parent = parseDN(dnOfOrgUnit, "ou")
+		dnOfOrgUnit = childDN("ou", orgUnitName, d.Get("parent").(string))
 	}
 
 	log.Printf("[DEBUG] Name of the DN is : %s ", dnOfOrgUnit)
 	log.Printf("[DEBUG] Searching the organizational unit from the AD : %s ", orgUnitName)
 
-	client := meta.(*ldap.Conn)
-
-	searchParam := "(distinguishedName=" + dnOfOrgUnit + ")"
-	_, searchBaseDN := parseDN(dnOfOrgUnit, "ou")
-
-	if d.Id() != "" {
-		searchParam = "(objectGUID=" + generateObjectIdQueryString(d.Id()) + ")"
-		searchBaseDN = extractDomainFromDN(dnOfOrgUnit)
-	}
-
-	log.Printf("[DEBUG] Search Parameters for organizational unit: %s ", searchParam)
+	client := timeoutClient(d, meta, schema.TimeoutRead)
 
-	searchRequest := ldap.NewSearchRequest(
-		searchBaseDN, // The base dn to search
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(&(objectClass=organizationalunit)"+searchParam+")", // The filter to apply
-		[]string{"dn", "ou", "description"},                  // A list attributes to retrieve
-		nil,
-	)
-
-	searchRequest.Controls = append(searchRequest.Controls, &ldapControlServerExtendDN{})
-
-	sr, err := client.Search(searchRequest)
+	orgUnit, err := getADEntry(d.Id(), dnOfOrgUnit, filterEqual("objectClass", "organizationalunit"), []string{"ou", "description", "objectGUID"}, client)
 	if err != nil {
 		log.Printf("[ERROR] Error while searching a organizational unit: %s", err)
 		return fmt.Errorf("Error while searching a organizational unit: %s", err)
 	}
-	if len(sr.Entries) == 0 {
+	if orgUnit == nil {
 		log.Println("[ERROR] Organizational Unit was not found")
 		d.SetId("")
-	} else {
-		if len(sr.Entries) > 1 {
-			log.Printf("[ERROR] Error found ambigious values for organizational unit: %s", orgUnitName)
-			return fmt.Errorf("Error found ambigious values for organizational unit: %s", orgUnitName)
-		}
-		orgUnit := sr.Entries[0]
-		orgID, orgDN := parseExtendedDN(orgUnit.DN)
-		orgUnitName, parent = parseDN(orgDN, "ou")
-		d.SetId(orgID)
-		d.Set("dn", orgDN)
-		d.Set("name", orgUnitName)
-		d.Set("description", orgUnit.GetAttributeValue("description"))
-		d.Set("parent", parent)
+		return nil
+	}
+
+	_, orgDN := parseExtendedDN(orgUnit.DN)
+	orgID, err := formatGUID(orgUnit.GetRawAttributeValue("objectGUID"))
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the objectGUID of the organizational unit: %s", err)
+		return fmt.Errorf("Error while reading the objectGUID of the organizational unit: %s", err)
+	}
+	dn, err := parseDN(orgDN)
+	if err != nil {
+		log.Printf("[ERROR] Error while parsing the DN of the organizational unit: %s", err)
+		return fmt.Errorf("Error while parsing the DN of the organizational unit: %s", err)
 	}
+	d.SetId(orgID)
+	d.Set("dn", orgDN)
+	d.Set("name", dn.Name())
+	d.Set("description", orgUnit.GetAttributeValue("description"))
+	d.Set("parent", dn.Parent().String())
 	return nil
 }
@@ -0,0 +1,80 @@
+package ad
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+	ldap "gopkg.in/ldap.v3"
+)
+
+func TestResourceADOrgUnit(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=Europe,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	resource := resourceOrgUnit()
+
+	state := testResourceApply(t, resource, nil, map[string]interface{}{
+		"name":   "Berlin",
+		"parent": "dc=example,dc=com",
+	}, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"name":        "Berlin",
+		"parent":      "dc=example,dc=com",
+		"dn":          "ou=Berlin,dc=example,dc=com",
+		"description": "",
+	})
+
+	// children follow the OU when it is moved
+	directory.add("cn=web01,ou=Berlin,dc=example,dc=com", map[string][]string{"objectClass": {"computer"}})
+
+	id := state.ID
+	state = testResourceApply(t, resource, state, map[string]interface{}{
+		"name":        "Munich",
+		"parent":      "OU=Europe,DC=example,DC=com",
+		"description": "the Munich office",
+	}, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"id":          id,
+		"name":        "Munich",
+		"parent":      "ou=Europe,dc=example,dc=com",
+		"dn":          "ou=Munich,ou=Europe,dc=example,dc=com",
+		"description": "the Munich office",
+	})
+	if directory.attribute("cn=web01,ou=Munich,ou=Europe,dc=example,dc=com", "cn") == nil {
+		t.Fatal("expected the computer to be moved along with the OU")
+	}
+
+	// OUs holding objects cannot be deleted
+	if _, err := resource.Apply(state, &terraform.InstanceDiff{Destroy: true}, conn); err == nil {
+		t.Fatal("expected an error deleting an OU which is not empty")
+	}
+	if err := conn.Del(ldap.NewDelRequest("cn=web01,ou=Munich,ou=Europe,dc=example,dc=com", nil)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testResourceApply(t, resource, state, nil, conn)
+	if state := testResourceRefresh(t, resource, state, conn); state != nil {
+		t.Fatalf("expected the organizational unit to be gone, got %v", state)
+	}
+}
+
+func TestResourceADOrgUnit_import(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=Berlin,dc=example,dc=com", map[string][]string{
+		"objectClass": {"organizationalUnit"},
+		"description": {"Berlin office"},
+	})
+	resource := resourceOrgUnit()
+
+	state := testResourceImport(t, resource, "OU=Berlin,DC=example,DC=com", conn)
+	testCheckEmptyPlan(t, resource, state, map[string]interface{}{
+		"name":        "Berlin",
+		"parent":      "dc=example,dc=com",
+		"description": "Berlin office",
+	}, conn)
+}
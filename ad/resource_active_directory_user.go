@@ -3,6 +3,8 @@ package ad
 import (
 	"fmt"
 	"log"
+	"strconv"
+	"time"
 
 	ldap "gopkg.in/ldap.v3"
 
@@ -10,11 +12,21 @@ import (
 )
 
 func resourceUser() *schema.Resource {
-	return &schema.Resource{
+	resource := &schema.Resource{
 		Create: resourceADUserCreate,
 		Read:   resourceADUserRead,
 		Update: resourceADUserUpdate,
 		Delete: resourceADUserDelete,
+		Importer: &schema.ResourceImporter{
+			State: resourceADUserImport,
+		},
+		SchemaVersion: 1,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Schema: map[string]*schema.Schema{
 			"username": {
 				Type:        schema.TypeString,
@@ -28,11 +40,96 @@ func resourceUser() *schema.Resource {
 				Required:    true,
 				Sensitive:   true,
 				ForceNew:    false,
+				// imported users have no password in their state, they keep
+				// their password until password_version changes
+				DiffSuppressFunc: func(k, old, new string, d *schema.ResourceData) bool {
+					return old == "" && d.Id() != "" && !d.HasChange("password_version")
+				},
 			},
-			"parent": {
+			"password_version": {
 				Type:        schema.TypeString,
-				Description: "The parent the domain belongs to. Could be either the DN of an OU or a DC.",
-				Required:    true,
+				Description: "Changing it sets the password again, e.g. to rotate a password whose changes are ignored",
+				Optional:    true,
+			},
+			"change_password_at_next_logon": {
+				Type:        schema.TypeBool,
+				Description: "Whether the user has to change the password set by Terraform at the next logon",
+				Optional:    true,
+				Default:     false,
+			},
+			"password_never_expires": {
+				Type:        schema.TypeBool,
+				Description: "Whether the password of the user never expires",
+				Optional:    true,
+				Default:     false,
+			},
+			"cannot_change_password": {
+				Type:        schema.TypeBool,
+				Description: "Whether the user is denied to change the password",
+				Optional:    true,
+				Default:     false,
+			},
+			"enabled": {
+				Type:        schema.TypeBool,
+				Description: "Whether the account of the user is enabled",
+				Optional:    true,
+				Default:     true,
+			},
+			"password_not_required": {
+				Type:        schema.TypeBool,
+				Description: "Whether the user may have an empty password",
+				Optional:    true,
+				Default:     false,
+			},
+			"smartcard_required": {
+				Type:        schema.TypeBool,
+				Description: "Whether the user has to log on with a smart card",
+				Optional:    true,
+				Default:     false,
+			},
+			"trusted_for_delegation": {
+				Type:        schema.TypeBool,
+				Description: "Whether services running as the user are trusted for Kerberos delegation",
+				Optional:    true,
+				Default:     false,
+			},
+			"trusted_to_auth_for_delegation": {
+				Type:        schema.TypeBool,
+				Description: "Whether services running as the user may use protocol transition for constrained delegation",
+				Optional:    true,
+				Default:     false,
+			},
+			"not_delegated": {
+				Type:        schema.TypeBool,
+				Description: "Whether the credentials of the user are never delegated, even to services trusted for delegation",
+				Optional:    true,
+				Default:     false,
+			},
+			"use_des_key_only": {
+				Type:        schema.TypeBool,
+				Description: "Whether the user is restricted to DES encryption for Kerberos",
+				Optional:    true,
+				Default:     false,
+			},
+			"dont_require_preauth": {
+				Type:        schema.TypeBool,
+				Description: "Whether the user may log on without Kerberos pre-authentication",
+				Optional:    true,
+				Default:     false,
+			},
+			"account_expires": {
+				Type:             schema.TypeString,
+				Description:      "The date the account expires as RFC 3339 timestamp, or never",
+				Optional:         true,
+				Default:          accountNeverExpires,
+				ValidateFunc:     validateAccountExpires,
+				DiffSuppressFunc: suppressEquivalentAccountExpires,
+			},
+			"parent": {
+				Type:             schema.TypeString,
+				Description:      "The parent the domain belongs to. Could be either the DN of an OU or a DC.",
+				Required:         true,
+				DiffSuppressFunc: suppressEquivalentDN,
 			},
 			"firstname": {
 				Type:        schema.TypeString,
@@ -63,6 +160,11 @@ func resourceUser() *schema.Resource {
 				Description: "The distinguished name of the user",
 				Computed:    true,
 			},
+			"sid": {
+				Type:        schema.TypeString,
+				Description: "The security identifier (SID) of the user",
+				Computed:    true,
+			},
 			"groups": {
 				Type: schema.TypeSet,
 				Elem: &schema.Schema{
@@ -72,6 +174,16 @@ func resourceUser() *schema.Resource {
 			},
 		},
 	}
+	for _, a := range userAttributes {
+		resource.Schema[a.key] = &schema.Schema{
+			Type:        schema.TypeString,
+			Description: a.description,
+			Optional:    true,
+		}
+	}
+	resource.Schema["manager"].DiffSuppressFunc = suppressEquivalentDN
+	resource.StateUpgraders = objectIDStateUpgraders(resource)
+	return resource
 }
 
 func resourceADUserCreate(d *schema.ResourceData, meta interface{}) error {
@@ -83,56 +195,216 @@ func resourceADUserCreate(d *schema.ResourceData, meta interface{}) error {
 	lastname := d.Get("lastname").(string)
 	name := fmt.Sprintf("%s %s", firstname, lastname)
 
-	dnOfUser := fmt.Sprintf("cn=%s,%s", name, parent)
+	dnOfUser := childDN("cn", name, parent)
 
 	log.Printf("[DEBUG] Name of the DN is : %s", dnOfUser)
 	log.Printf("[DEBUG] Adding the user to the AD : %s", name)
 
-	client := meta.(*ldap.Conn)
+	session := pinClient(meta)
+	defer session.Close()
+	client := timeoutClient(d, session, schema.TimeoutCreate)
+
+	attributes := make(map[string]string)
+	for _, a := range userAttributes {
+		attributes[a.attribute] = d.Get(a.key).(string)
+	}
 
-	err := addUserToAD(username, firstname, lastname, dnOfUser, client, description)
+	err := addUserToAD(username, firstname, lastname, dnOfUser, client, description, attributes)
 	if err != nil {
 		log.Printf("[ERROR] Error while adding a user to the AD : %s", err)
 		return fmt.Errorf("Error while adding a user to the AD %s", err)
 	}
+	err = waitForEntry(client, dnOfUser, d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		log.Printf("[ERROR] Error while waiting for the user to become visible : %s", err)
+		return fmt.Errorf("Error while waiting for the user to become visible %s", err)
+	}
 	d.Set("dn", dnOfUser)
 	err = setUserPassword(dnOfUser, password, client)
 	if err != nil {
 		log.Printf("[ERROR] Error while changing password of user : %s", err)
 		return fmt.Errorf("Error while changing password of user %s", err)
 	}
-	err = activateUser(dnOfUser, client)
+	err = updateUserAccountControlFlags(d, dnOfUser, client)
 	if err != nil {
-		log.Printf("[ERROR] Error while activating of user : %s", err)
-		return fmt.Errorf("Error while activating of user %s", err)
+		log.Printf("[ERROR] Error while setting the account flags of user : %s", err)
+		return fmt.Errorf("Error while setting the account flags of user %s", err)
+	}
+	err = updateUserPasswordSettings(d, dnOfUser, client)
+	if err != nil {
+		log.Printf("[ERROR] Error while setting the password settings of user : %s", err)
+		return fmt.Errorf("Error while setting the password settings of user %s", err)
+	}
+	if accountExpires := d.Get("account_expires").(string); accountExpires != accountNeverExpires {
+		err = setAccountExpires(dnOfUser, accountExpires, client)
+		if err != nil {
+			log.Printf("[ERROR] Error while setting the expiration date of user : %s", err)
+			return fmt.Errorf("Error while setting the expiration date of user %s", err)
+		}
 	}
 	log.Printf("[DEBUG] User added to AD successfully: %s", username)
-	return resourceADUserRead(d, meta)
+	return resourceADUserRead(d, session)
 }
 
 func resourceADUserUpdate(d *schema.ResourceData, meta interface{}) error {
-	//TODO
-	return resourceADUserRead(d, meta)
-}
-
-func resourceADUserDelete(d *schema.ResourceData, meta interface{}) error {
 	parent := d.Get("parent").(string)
 	firstname := d.Get("firstname").(string)
 	lastname := d.Get("lastname").(string)
 	name := fmt.Sprintf("%s %s", firstname, lastname)
 
-	dnOfUser := fmt.Sprintf("cn=%s,%s", name, parent)
+	var err error
+	session := pinClient(meta)
+	defer session.Close()
+	client := timeoutClient(d, session, schema.TimeoutUpdate)
+
+	dnOfUser := currentDN(d)
+	origName := d.Get("name").(string)
+	origParent, _ := d.GetChange("parent")
+	if !d.HasChange("firstname") && !d.HasChange("lastname") {
+		// the CN of imported users may differ from the full name
+		name = origName
+	}
+
+	if name != origName {
+		// first: rename user, its CN is the full name
+		log.Printf("[DEBUG] Name of the DN is : %s", dnOfUser)
+		log.Printf("[DEBUG] About to rename the user to %s", name)
+		err = renameADEntry(dnOfUser, rdnString("cn", name), client)
+		if err == nil {
+			dnOfUser = childDN("cn", name, origParent.(string))
+		}
+	}
+
+	if err == nil && d.HasChange("parent") {
+		// next: move user to new parent
+		log.Printf("[DEBUG] Name of the DN is : %s", dnOfUser)
+		log.Printf("[DEBUG] About to move the user to %s", parent)
+		err = moveADEntry(dnOfUser, rdnString("cn", name), parent, client)
+		if err == nil {
+			dnOfUser = childDN("cn", name, parent)
+		}
+	}
+
+	if err == nil && name != origName {
+		log.Printf("[DEBUG] found new display name %s. Do update", name)
+		err = updateADEntry(dnOfUser, "displayName", name, client)
+	}
+
+	log.Printf("[DEBUG] Name of the DN is : %s", dnOfUser)
+
+	fields := []userAttribute{
+		{key: "username", attribute: "sAMAccountName"},
+		{key: "firstname", attribute: "givenName"},
+		{key: "lastname", attribute: "sn"},
+		{key: "description", attribute: "description"},
+	}
+	for _, field := range append(fields, userAttributes...) {
+		key, attribute := field.key, field.attribute
+		if err == nil && d.HasChange(key) {
+			new := d.Get(key).(string)
+			log.Printf("[DEBUG] found new %s %s. Do update", key, new)
+			err = updateADEntry(dnOfUser, attribute, new, client)
+		}
+	}
+
+	oldPassword, _ := d.GetChange("password")
+	if err == nil && (d.HasChange("password") && oldPassword != "" || d.HasChange("password_version")) {
+		// the password of imported users is unknown and not reset unless
+		// password_version changes
+		log.Printf("[DEBUG] found new password or password version. Do update")
+		err = setUserPassword(dnOfUser, d.Get("password").(string), client)
+	}
+
+	if err == nil {
+		err = updateUserAccountControlFlags(d, dnOfUser, client)
+	}
+
+	if err == nil {
+		err = updateUserPasswordSettings(d, dnOfUser, client)
+	}
+
+	if err == nil && d.HasChange("account_expires") {
+		accountExpires := d.Get("account_expires").(string)
+		log.Printf("[DEBUG] found new expiration date %s. Do update", accountExpires)
+		err = setAccountExpires(dnOfUser, accountExpires, client)
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] Error while modifying a user from AD : %s ", err)
+		return fmt.Errorf("Error while modifying a user from AD %s", err)
+	}
+
+	d.Set("dn", dnOfUser)
+	return resourceADUserRead(d, session)
+}
+
+// updateUserAccountControlFlags writes the flags of the userAccountControl
+// which are new or changed. A new user is created disabled and without the
+// need for a password, so all of them are written on creation.
+func updateUserAccountControlFlags(d *schema.ResourceData, dnOfUser string, client adClient) error {
+	var set, clear uint32
+	for _, f := range userAccountControlFlags {
+		if !d.IsNewResource() && !d.HasChange(f.key) {
+			continue
+		}
+		if d.Get(f.key).(bool) != f.inverted {
+			set |= f.flag
+		} else {
+			clear |= f.flag
+		}
+	}
+	if set == 0 && clear == 0 {
+		return nil
+	}
+	if d.IsNewResource() {
+		set |= uacNormalAccount
+	}
+	log.Printf("[DEBUG] Setting the userAccountControl flags %#x and clearing %#x of %s", set, clear, dnOfUser)
+	return updateUserAccountControl(dnOfUser, set, clear, client)
+}
+
+// updateUserPasswordSettings writes the password settings which are new or
+// changed. A password set by Terraform is only to be changed at the next
+// logon if change_password_at_next_logon is set, so it is written again
+// whenever the password is.
+func updateUserPasswordSettings(d *schema.ResourceData, dnOfUser string, client adClient) error {
+	isNew := d.IsNewResource()
+	passwordSet := isNew || d.HasChange("password") || d.HasChange("password_version")
+	expired := d.Get("change_password_at_next_logon").(bool)
+
+	if (passwordSet && expired) || (!isNew && d.HasChange("change_password_at_next_logon")) {
+		log.Printf("[DEBUG] Setting the password of %s to be changed at the next logon: %t", dnOfUser, expired)
+		if err := setPasswordExpired(dnOfUser, expired, client); err != nil {
+			return err
+		}
+	}
+
+	if cannotChange := d.Get("cannot_change_password").(bool); (isNew && cannotChange) || (!isNew && d.HasChange("cannot_change_password")) {
+		log.Printf("[DEBUG] Denying %s to change the password: %t", dnOfUser, cannotChange)
+		if err := setUserCannotChangePassword(dnOfUser, cannotChange, client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resourceADUserDelete(d *schema.ResourceData, meta interface{}) error {
+	name := d.Get("name").(string)
 
-	log.Printf("[DEBUG] Name of the DN is: %s", dnOfUser)
 	log.Printf("[DEBUG] Deleting the user from the AD: %s", name)
 
-	resourceADUserRead(d, meta)
+	session := pinClient(meta)
+	defer session.Close()
+	resourceADUserRead(d, session)
 	if d.Id() == "" {
 		log.Printf("[DEBUG] User has been already removed from AD: %s", name)
 		return nil
 	}
 
-	client := meta.(*ldap.Conn)
+	dnOfUser := currentDN(d)
+	log.Printf("[DEBUG] Name of the DN is: %s", dnOfUser)
+
+	client := timeoutClient(d, session, schema.TimeoutDelete)
 
 	err := deleteUserFromAD(dnOfUser, client)
 	if err != nil {
@@ -143,80 +415,109 @@ func resourceADUserDelete(d *schema.ResourceData, meta interface{}) error {
 	return nil
 }
 
-func resourceADUserRead(d *schema.ResourceData, meta interface{}) error {
-	var username string
-	var searchParam string
-	var parent string
+// resourceADUserImport imports a user. change_password_at_next_logon only
+// applies to passwords set by Terraform and cannot be read, so imported users
+// start with its default.
+func resourceADUserImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	imported, err := importADObject("User", true)(d, meta)
+	if err != nil {
+		return nil, err
+	}
+	d.Set("change_password_at_next_logon", false)
+	return imported, nil
+}
 
+func resourceADUserRead(d *schema.ResourceData, meta interface{}) error {
+	username := d.Get("username").(string)
 	dnOfUser := d.Get("dn").(string)
-
-	if dnOfUser == "" {
-		username = d.Get("username").(string)
-		parent = d.Get("parent").(string)
-
-		dnOfUser += parent
-		searchParam = "(sAMAccountName=" + username + ")"
-	} else {
-		searchParam = "(distinguishedName=" + dnOfUser + ")"
+	attributes := []string{"cn", "description", "givenName", "sn", "sAMAccountName", "memberOf", "userAccountControl", "accountExpires", "objectGUID", "objectSid"}
+	for _, a := range userAttributes {
+		attributes = append(attributes, a.attribute)
 	}
 
-	_, searchBaseDN := parseDN(dnOfUser, "cn")
-
 	log.Printf("[DEBUG] Name of the DN is : %s", dnOfUser)
 	log.Printf("[DEBUG] Searching the user in the AD : %s", username)
 
-	client := meta.(*ldap.Conn)
+	client := timeoutClient(d, meta, schema.TimeoutRead)
 
-	if d.Id() != "" {
-		searchParam = "(objectGUID=" + generateObjectIdQueryString(d.Id()) + ")"
-		searchBaseDN = extractDomainFromDN(dnOfUser)
+	var user *ldap.Entry
+	var err error
+	if d.Id() == "" && dnOfUser == "" {
+		// the data source only knows the username and the parent to search in
+		user, err = searchADUser(username, d.Get("parent").(string), attributes, client)
+	} else {
+		user, err = getADEntry(d.Id(), dnOfUser, filterEqual("objectClass", "User"), attributes, client)
 	}
-
-	log.Printf("[DEBUG] Search Parameters for user: %s ", searchParam)
-
-	searchRequest := ldap.NewSearchRequest(
-		searchBaseDN, // The base dn to search
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(&(objectClass=User)"+searchParam+")",                                               // The filter to apply
-		[]string{"dn", "cn", "description", "givenName", "sn", "sAMAccountName", "memberOf"}, // A list attributes to retrieve
-		nil,
-	)
-
-	searchRequest.Controls = append(searchRequest.Controls, &ldapControlServerExtendDN{})
-
-	sr, err := client.Search(searchRequest)
 	if err != nil {
 		log.Printf("[ERROR] Error while searching a user: %s", err)
 		return fmt.Errorf("Error while searching a user: %s", err)
 	}
-	if len(sr.Entries) == 0 {
+	if user == nil {
 		log.Println("[ERROR] User was not found")
 		d.SetId("")
-	} else {
-		if len(sr.Entries) > 1 {
-			log.Printf("[ERROR] Error found ambigious values for user: %s", username)
-			return fmt.Errorf("Error found ambigious values for user: %s", username)
-		}
-		var name string
-		user := sr.Entries[0]
-		userID, userDN := parseExtendedDN(user.DN)
-		name, parent = parseDN(userDN, "cn")
-
-		var userGroups []string
-		for _, group := range user.GetAttributeValues("memberOf") {
-			_, dn := parseExtendedDN(group)
-			userGroups = append(userGroups, dn)
-		}
+		return nil
+	}
+
+	_, userDN := parseExtendedDN(user.DN)
+	userID, err := formatGUID(user.GetRawAttributeValue("objectGUID"))
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the objectGUID of the user: %s", err)
+		return fmt.Errorf("Error while reading the objectGUID of the user: %s", err)
+	}
+	sid, err := formatSID(user.GetRawAttributeValue("objectSid"))
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the objectSid of the user: %s", err)
+		return fmt.Errorf("Error while reading the objectSid of the user: %s", err)
+	}
+	dn, err := parseDN(userDN)
+	if err != nil {
+		log.Printf("[ERROR] Error while parsing the DN of the user: %s", err)
+		return fmt.Errorf("Error while parsing the DN of the user: %s", err)
+	}
 
-		d.SetId(userID)
-		d.Set("dn", userDN)
-		d.Set("username", user.GetAttributeValue("sAMAccountName"))
-		d.Set("name", name)
-		d.Set("firstname", user.GetAttributeValue("givenName"))
-		d.Set("lastname", user.GetAttributeValue("sn"))
-		d.Set("description", user.GetAttributeValue("description"))
-		d.Set("parent", parent)
-		d.Set("groups", userGroups)
+	uac, err := strconv.ParseUint(user.GetAttributeValue("userAccountControl"), 10, 32)
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the userAccountControl of the user: %s", err)
+		return fmt.Errorf("Error while reading the userAccountControl of the user: %s", err)
+	}
+	accountExpires, err := formatAccountExpires(user.GetAttributeValue("accountExpires"))
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the accountExpires of the user: %s", err)
+		return fmt.Errorf("Error while reading the accountExpires of the user: %s", err)
+	}
+	cannotChangePassword, err := getUserCannotChangePassword(userDN, client)
+	if err != nil {
+		log.Printf("[ERROR] Error while reading the security descriptor of the user: %s", err)
+		return fmt.Errorf("Error while reading the security descriptor of the user: %s", err)
+	}
+
+	var userGroups []string
+	for _, group := range user.GetAttributeValues("memberOf") {
+		_, groupDN := parseExtendedDN(group)
+		userGroups = append(userGroups, groupDN)
+	}
+
+	d.SetId(userID)
+	d.Set("dn", userDN)
+	d.Set("sid", sid)
+	d.Set("username", user.GetAttributeValue("sAMAccountName"))
+	d.Set("name", dn.Name())
+	d.Set("firstname", user.GetAttributeValue("givenName"))
+	d.Set("lastname", user.GetAttributeValue("sn"))
+	d.Set("description", user.GetAttributeValue("description"))
+	d.Set("parent", dn.Parent().String())
+	d.Set("groups", userGroups)
+	for _, a := range userAttributes {
+		d.Set(a.key, user.GetAttributeValue(a.attribute))
+	}
+	if manager := user.GetAttributeValue("manager"); manager != "" {
+		_, managerDN := parseExtendedDN(manager)
+		d.Set("manager", managerDN)
+	}
+	for _, f := range userAccountControlFlags {
+		d.Set(f.key, f.value(uint32(uac)))
 	}
+	d.Set("cannot_change_password", cannotChangePassword)
+	d.Set("account_expires", accountExpires)
 	return nil
 }
@@ -3,51 +3,58 @@ package ad
 import (
 	"fmt"
 	"log"
-	"github.com/google/uuid"
-
-	ldap "gopkg.in/ldap.v3"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
 func resourceUserAttachment() *schema.Resource {
-  return &schema.Resource{
-    Create: resourceADUserAttachmentCreate,
-    Read:   resourceADUserAttachmentRead,
-    Update: resourceADUserAttachmentUpdate,
-    Delete: resourceADUserAttachmentDelete,
-    Schema: map[string]*schema.Schema{
-      "group_dn": {
-        Type:         schema.TypeString,
-        Description:  "The dn of the group to add the user to.",
-        Required:     true,
-        ForceNew:     true,
-      },
-      "user_dn": {
-        Type:         schema.TypeString,
-        Description:  "The dn of the user to attache to the the group.",
-        Required:     true,
-        ForceNew:     true,
-      },
+	return &schema.Resource{
+		Create: resourceADUserAttachmentCreate,
+		Read:   resourceADUserAttachmentRead,
+		Update: resourceADUserAttachmentUpdate,
+		Delete: resourceADUserAttachmentDelete,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
+		Schema: map[string]*schema.Schema{
+			"group_dn": {
+				Type:        schema.TypeString,
+				Description: "The dn of the group to add the user to.",
+				Required:    true,
+				ForceNew:    true,
+			},
+			"user_dn": {
+				Type:        schema.TypeString,
+				Description: "The dn of the user to attache to the the group.",
+				Required:    true,
+				ForceNew:    true,
+			},
 			"name": {
-        Type:         schema.TypeString,
-        Description:  "The for the attachment.",
-        Optional:     true,
-        ForceNew:     false,
-      },
-    },
-  }
+				Type:        schema.TypeString,
+				Description: "The for the attachment.",
+				Optional:    true,
+				ForceNew:    false,
+			},
+		},
+	}
 }
 
 func resourceADUserAttachmentCreate(d *schema.ResourceData, meta interface{}) error {
-	groupDN 	:= d.Get("group_dn").(string)
-	userDN 		:= d.Get("user_dn").(string)
+	groupDN := d.Get("group_dn").(string)
+	userDN := d.Get("user_dn").(string)
 	/*
-	groupName, _ := parseDN(groupDN, "cn")
-	userName, _ := parseDN(userDN, "cn")
+		groupName, _ := parseDN(groupDN, "cn")
+		userName, _ := parseDN(userDN, "cn")
 	*/
 
-	client 	:= meta.(*ldap.Conn)
+	session := pinClient(meta)
+	defer session.Close()
+	client := timeoutClient(d, session, schema.TimeoutCreate)
 
 	err := addMemberToGroup(groupDN, userDN, client)
 	if err != nil {
@@ -57,7 +64,7 @@ func resourceADUserAttachmentCreate(d *schema.ResourceData, meta interface{}) er
 
 	d.SetId(uuid.New().String())
 
-	return resourceADUserAttachmentRead(d, meta)
+	return resourceADUserAttachmentRead(d, session)
 }
 
 func resourceADUserAttachmentUpdate(d *schema.ResourceData, meta interface{}) error {
@@ -65,14 +72,16 @@ func resourceADUserAttachmentUpdate(d *schema.ResourceData, meta interface{}) er
 	//resourceADUserAttachmentDelete(d, meta)
 	//resourceADUserAttachmentCreate(d, meta)
 
-  return resourceADUserAttachmentRead(d, meta)
+	return resourceADUserAttachmentRead(d, meta)
 }
 
 func resourceADUserAttachmentDelete(d *schema.ResourceData, meta interface{}) error {
 	groupDN := d.Get("group_dn").(string)
-	userDN 	:= d.Get("user_dn").(string)
+	userDN := d.Get("user_dn").(string)
 
-	client 	:= meta.(*ldap.Conn)
+	session := pinClient(meta)
+	defer session.Close()
+	client := timeoutClient(d, session, schema.TimeoutDelete)
 
 	err := removeMemberFromGroup(groupDN, userDN, client)
 	if err != nil {
@@ -82,10 +91,10 @@ func resourceADUserAttachmentDelete(d *schema.ResourceData, meta interface{}) er
 
 	d.SetId("")
 
-  return resourceADUserAttachmentRead(d, meta)
+	return resourceADUserAttachmentRead(d, session)
 }
 
 func resourceADUserAttachmentRead(d *schema.ResourceData, meta interface{}) error {
 
-  return nil
+	return nil
 }
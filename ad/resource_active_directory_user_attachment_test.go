@@ -0,0 +1,25 @@
+package ad
+
+import "testing"
+
+func TestResourceADUserAttachment(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("cn=Jane Doe,dc=example,dc=com", map[string][]string{"objectClass": {"user"}})
+	directory.add("cn=Operators,dc=example,dc=com", map[string][]string{"objectClass": {"group"}})
+	resource := resourceUserAttachment()
+
+	state := testResourceApply(t, resource, nil, map[string]interface{}{
+		"group_dn": "cn=Operators,dc=example,dc=com",
+		"user_dn":  "cn=Jane Doe,dc=example,dc=com",
+	}, conn)
+	if state == nil || state.ID == "" {
+		t.Fatalf("expected the attachment to be created, got %v", state)
+	}
+	testCheckMembers(t, directory, "cn=Operators,dc=example,dc=com", "cn=Jane Doe,dc=example,dc=com")
+
+	testResourceApply(t, resource, state, nil, conn)
+	testCheckMembers(t, directory, "cn=Operators,dc=example,dc=com")
+}
@@ -0,0 +1,485 @@
+package ad
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+	ldap "gopkg.in/ldap.v3"
+)
+
+func TestResourceADUser(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=People,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	resource := resourceUser()
+
+	state := testResourceApply(t, resource, nil, map[string]interface{}{
+		"username":    "jdoe",
+		"password":    "Secret123!",
+		"firstname":   "Jane",
+		"lastname":    "Doe",
+		"parent":      "ou=People,dc=example,dc=com",
+		"description": "operator",
+	}, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"username":    "jdoe",
+		"name":        "Jane Doe",
+		"firstname":   "Jane",
+		"lastname":    "Doe",
+		"parent":      "ou=People,dc=example,dc=com",
+		"dn":          "cn=Jane Doe,ou=People,dc=example,dc=com",
+		"description": "operator",
+		"groups.#":    "0",
+	})
+	if !strings.HasPrefix(state.Attributes["sid"], testDomainSID+"-") {
+		t.Fatalf("expected a SID of the domain, got %s", state.Attributes["sid"])
+	}
+	dn := "cn=Jane Doe,ou=People,dc=example,dc=com"
+	if password := directory.password(dn); password != "Secret123!" {
+		t.Fatalf("expected the password to be set, got %q", password)
+	}
+	if uac := directory.attribute(dn, "userAccountControl"); len(uac) != 1 || uac[0] != "512" {
+		t.Fatalf("expected the user to be enabled, got %v", uac)
+	}
+
+	id := state.ID
+	state = testResourceApply(t, resource, state, map[string]interface{}{
+		"username":  "jsmith",
+		"password":  "Secret123!",
+		"firstname": "Jane",
+		"lastname":  "Smith",
+		"parent":    "dc=example,dc=com",
+	}, conn)
+	dn = "cn=Jane Smith,dc=example,dc=com"
+	testCheckAttributes(t, state, map[string]string{
+		"id":          id,
+		"username":    "jsmith",
+		"name":        "Jane Smith",
+		"lastname":    "Smith",
+		"parent":      "dc=example,dc=com",
+		"dn":          dn,
+		"description": "",
+	})
+	for attribute, expected := range map[string][]string{
+		"sAMAccountName": {"jsmith"},
+		"givenName":      {"Jane"},
+		"sn":             {"Smith"},
+		"displayName":    {"Jane Smith"},
+		"description":    nil,
+	} {
+		if values := directory.attribute(dn, attribute); !reflect.DeepEqual(values, expected) {
+			t.Errorf("expected %s to be %v, got %v", attribute, expected, values)
+		}
+	}
+
+	// a plan without changes converges
+	if diff, err := resource.Diff(state, terraform.NewResourceConfigRaw(map[string]interface{}{
+		"username":  "jsmith",
+		"password":  "Secret123!",
+		"firstname": "Jane",
+		"lastname":  "Smith",
+		"parent":    "DC=example,DC=com",
+	}), conn); err != nil || !diff.Empty() {
+		t.Fatalf("expected no changes, got %v, %v", diff, err)
+	}
+
+	// group memberships made outside of Terraform show up on refresh
+	directory.add("cn=Operators,dc=example,dc=com", map[string][]string{"objectClass": {"group"}})
+	modifyRequest := ldap.NewModifyRequest("cn=Operators,dc=example,dc=com", nil)
+	modifyRequest.Add("member", []string{dn})
+	if err := conn.Modify(modifyRequest); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	state = testResourceRefresh(t, resource, state, conn)
+	testCheckAttributes(t, state, map[string]string{"groups.#": "1"})
+	if !testStateContains(state.Attributes, "groups.", "cn=Operators,dc=example,dc=com") {
+		t.Fatalf("expected the user to be a member of the group, got %v", state.Attributes)
+	}
+
+	testResourceApply(t, resource, state, nil, conn)
+	if state := testResourceRefresh(t, resource, state, conn); state != nil {
+		t.Fatalf("expected the user to be gone, got %v", state)
+	}
+	// the group lost the member along with the user
+	testCheckMembers(t, directory, "cn=Operators,dc=example,dc=com")
+}
+
+func TestResourceADUser_password(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	resource := resourceUser()
+	dn := "cn=Jane Doe,dc=example,dc=com"
+	config := map[string]interface{}{
+		"username":                      "jdoe",
+		"password":                      "Initial123!",
+		"firstname":                     "Jane",
+		"lastname":                      "Doe",
+		"parent":                        "dc=example,dc=com",
+		"change_password_at_next_logon": true,
+		"password_never_expires":        true,
+		"cannot_change_password":        true,
+	}
+	check := func(password string, expired bool, uac string, cannotChange bool) {
+		t.Helper()
+		if p := directory.password(dn); p != password {
+			t.Errorf("expected the password %q, got %q", password, p)
+		}
+		if pwdLastSet := directory.attribute(dn, "pwdLastSet"); (pwdLastSet[0] == "0") != expired {
+			t.Errorf("expected the password to be expired: %t, got pwdLastSet %v", expired, pwdLastSet)
+		}
+		if u := directory.attribute(dn, "userAccountControl"); len(u) != 1 || u[0] != uac {
+			t.Errorf("expected userAccountControl %s, got %v", uac, u)
+		}
+		if c, err := getUserCannotChangePassword(dn, conn); err != nil || c != cannotChange {
+			t.Errorf("expected the user to be denied to change the password: %t, got %t, %v", cannotChange, c, err)
+		}
+	}
+
+	state := testResourceApply(t, resource, nil, config, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"change_password_at_next_logon": "true",
+		"password_never_expires":        "true",
+		"cannot_change_password":        "true",
+	})
+	check("Initial123!", true, "66048", true)
+
+	// a rotated password has to be changed at the next logon as well
+	config["password"] = "Rotated123!"
+	state = testResourceApply(t, resource, state, config, conn)
+	check("Rotated123!", true, "66048", true)
+
+	// bumping the version sets the password again, even if it was changed
+	// outside of Terraform
+	if err := setUserPassword(dn, "Changed123!", conn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	config["password_version"] = "2"
+	config["change_password_at_next_logon"] = false
+	config["password_never_expires"] = false
+	config["cannot_change_password"] = false
+	state = testResourceApply(t, resource, state, config, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"password_version":              "2",
+		"change_password_at_next_logon": "false",
+		"password_never_expires":        "false",
+		"cannot_change_password":        "false",
+	})
+	check("Rotated123!", false, "512", false)
+
+	// settings changed outside of Terraform show up on refresh
+	if err := updateUserAccountControl(dn, uacDontExpirePassword, 0, conn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := setUserCannotChangePassword(dn, true, conn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	state = testResourceRefresh(t, resource, state, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"password_never_expires": "true",
+		"cannot_change_password": "true",
+	})
+}
+
+func TestResourceADUser_accountControl(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	resource := resourceUser()
+	dn := "cn=Jane Doe,dc=example,dc=com"
+	config := map[string]interface{}{
+		"username":           "jdoe",
+		"password":           "Secret123!",
+		"firstname":          "Jane",
+		"lastname":           "Doe",
+		"parent":             "dc=example,dc=com",
+		"enabled":            false,
+		"smartcard_required": true,
+	}
+	checkUAC := func(expected string) {
+		t.Helper()
+		if uac := directory.attribute(dn, "userAccountControl"); len(uac) != 1 || uac[0] != expected {
+			t.Errorf("expected userAccountControl %s, got %v", expected, uac)
+		}
+	}
+
+	// created disabled, the password is required though
+	state := testResourceApply(t, resource, nil, config, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"enabled":               "false",
+		"smartcard_required":    "true",
+		"password_not_required": "false",
+		"not_delegated":         "false",
+	})
+	checkUAC("262658")
+
+	// flags not managed by Terraform are kept
+	if err := updateADEntry(dn, "userAccountControl", "262666", conn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	config["enabled"] = true
+	config["not_delegated"] = true
+	state = testResourceApply(t, resource, state, config, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"enabled":            "true",
+		"smartcard_required": "true",
+		"not_delegated":      "true",
+	})
+	checkUAC("1311240")
+
+	// flags changed outside of Terraform show up on refresh
+	if err := updateUserAccountControl(dn, uacDontRequirePreauth, uacSmartcardRequired, conn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	state = testResourceRefresh(t, resource, state, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"smartcard_required":   "false",
+		"dont_require_preauth": "true",
+	})
+	diff, err := resource.Diff(state, terraform.NewResourceConfigRaw(config), conn)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if diff == nil || diff.Attributes["smartcard_required"] == nil || diff.Attributes["dont_require_preauth"] == nil {
+		t.Fatalf("expected the flags to be changed back, got %v", diff)
+	}
+}
+
+func TestResourceADUser_attributes(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("cn=John Boss,dc=example,dc=com", map[string][]string{"objectClass": {"user"}, "sAMAccountName": {"jboss"}})
+	resource := resourceUser()
+	dn := "cn=Jane Doe,dc=example,dc=com"
+	config := map[string]interface{}{
+		"username":       "jdoe",
+		"password":       "Secret123!",
+		"firstname":      "Jane",
+		"lastname":       "Doe",
+		"parent":         "dc=example,dc=com",
+		"principal_name": "jdoe@example.com",
+		"email":          "jane.doe@example.com",
+		"department":     "Operations",
+		"employee_id":    "4711",
+		"office":         "B 2.17",
+		"city":           "Springfield",
+		"country":        "US",
+		"manager":        "cn=John Boss,dc=example,dc=com",
+	}
+	checkAttributes := func(expected map[string][]string) {
+		t.Helper()
+		for attribute, values := range expected {
+			if v := directory.attribute(dn, attribute); !reflect.DeepEqual(v, values) {
+				t.Errorf("expected %s to be %v, got %v", attribute, values, v)
+			}
+		}
+	}
+
+	state := testResourceApply(t, resource, nil, config, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"principal_name": "jdoe@example.com",
+		"email":          "jane.doe@example.com",
+		"manager":        "cn=John Boss,dc=example,dc=com",
+		"title":          "",
+	})
+	checkAttributes(map[string][]string{
+		"userPrincipalName":          {"jdoe@example.com"},
+		"mail":                       {"jane.doe@example.com"},
+		"employeeID":                 {"4711"},
+		"physicalDeliveryOfficeName": {"B 2.17"},
+		"l":                          {"Springfield"},
+		"c":                          {"US"},
+		"manager":                    {"cn=John Boss,dc=example,dc=com"},
+		"title":                      nil,
+	})
+
+	config["title"] = "Engineer"
+	config["department"] = "Engineering"
+	delete(config, "office")
+	delete(config, "manager")
+	state = testResourceApply(t, resource, state, config, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"title":      "Engineer",
+		"department": "Engineering",
+		"office":     "",
+		"manager":    "",
+	})
+	checkAttributes(map[string][]string{
+		"title":                      {"Engineer"},
+		"department":                 {"Engineering"},
+		"physicalDeliveryOfficeName": nil,
+		"manager":                    nil,
+		"mail":                       {"jane.doe@example.com"},
+	})
+
+	// attributes changed outside of Terraform show up on refresh
+	if err := updateADEntry(dn, "mobile", "+1 555 0100", conn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	state = testResourceRefresh(t, resource, state, conn)
+	testCheckAttributes(t, state, map[string]string{"mobile": "+1 555 0100"})
+	diff, err := resource.Diff(state, terraform.NewResourceConfigRaw(config), conn)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if diff == nil || diff.Attributes["mobile"] == nil {
+		t.Fatalf("expected the mobile number to be removed, got %v", diff)
+	}
+}
+
+func TestResourceADUser_accountExpires(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	resource := resourceUser()
+	dn := "cn=Jane Doe,dc=example,dc=com"
+	config := map[string]interface{}{
+		"username":        "jdoe",
+		"password":        "Secret123!",
+		"firstname":       "Jane",
+		"lastname":        "Doe",
+		"parent":          "dc=example,dc=com",
+		"account_expires": "2027-01-01T02:00:00+02:00",
+	}
+
+	state := testResourceApply(t, resource, nil, config, conn)
+	testCheckAttributes(t, state, map[string]string{"account_expires": "2027-01-01T00:00:00Z"})
+	if v := directory.attribute(dn, "accountExpires"); !reflect.DeepEqual(v, []string{"134432352000000000"}) {
+		t.Fatalf("expected the account to expire, got %v", v)
+	}
+	// the same instant in another time zone is no change
+	if diff, err := resource.Diff(state, terraform.NewResourceConfigRaw(config), conn); err != nil || !diff.Empty() {
+		t.Fatalf("expected no changes, got %v, %v", diff, err)
+	}
+
+	// an expiration date changed outside of Terraform shows up in the plan
+	if err := updateADEntry(dn, "accountExpires", "134747712000000000", conn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	state = testResourceRefresh(t, resource, state, conn)
+	testCheckAttributes(t, state, map[string]string{"account_expires": "2028-01-01T00:00:00Z"})
+	diff, err := resource.Diff(state, terraform.NewResourceConfigRaw(config), conn)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if diff == nil || diff.Attributes["account_expires"] == nil {
+		t.Fatalf("expected the expiration date to be changed back, got %v", diff)
+	}
+
+	config["account_expires"] = "never"
+	state = testResourceApply(t, resource, state, config, conn)
+	testCheckAttributes(t, state, map[string]string{"account_expires": "never"})
+	if v := directory.attribute(dn, "accountExpires"); !reflect.DeepEqual(v, []string{"9223372036854775807"}) {
+		t.Fatalf("expected the account to never expire, got %v", v)
+	}
+}
+
+func TestResourceADUser_import(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=People,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	directory.add("cn=Jane Doe,ou=People,dc=example,dc=com", map[string][]string{
+		"objectClass":        {"top", "person", "user"},
+		"sAMAccountName":     {"jdoe"},
+		"givenName":          {"Jane"},
+		"sn":                 {"Doe"},
+		"description":        {"operator"},
+		"mail":               {"jane.doe@example.com"},
+		"userAccountControl": {"66048"},
+	})
+	resource := resourceUser()
+
+	state := testResourceImport(t, resource, "jdoe", conn)
+	testCheckAttributes(t, state, map[string]string{"password_never_expires": "true"})
+	// the password cannot be read back, imported users keep their password
+	config := map[string]interface{}{
+		"username":               "jdoe",
+		"password":               "Secret123!",
+		"firstname":              "Jane",
+		"lastname":               "Doe",
+		"parent":                 "ou=People,dc=example,dc=com",
+		"description":            "operator",
+		"email":                  "jane.doe@example.com",
+		"password_never_expires": true,
+	}
+	testCheckEmptyPlan(t, resource, state, config, conn)
+
+	dn := "cn=Jane Doe,ou=People,dc=example,dc=com"
+	config["description"] = "administrator"
+	state = testResourceApply(t, resource, state, config, conn)
+	if p := directory.password(dn); p != "" {
+		t.Fatalf("expected the password of the imported user to be kept, got %q", p)
+	}
+
+	// until password_version changes
+	config["password_version"] = "1"
+	testResourceApply(t, resource, state, config, conn)
+	if p := directory.password(dn); p != "Secret123!" {
+		t.Fatalf("expected the password to be set, got %q", p)
+	}
+}
+
+func TestResourceADUser_importedName(t *testing.T) {
+	directory, conn, done := testDirectoryClient(t)
+	defer done()
+
+	directory.add("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}})
+	directory.add("ou=People,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	directory.add("ou=Staff,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+	directory.add(`cn=Doe\, Jane,ou=People,dc=example,dc=com`, map[string][]string{
+		"objectClass":        {"top", "person", "user"},
+		"sAMAccountName":     {"jdoe"},
+		"givenName":          {"Jane"},
+		"sn":                 {"Doe"},
+		"userAccountControl": {"512"},
+	})
+	resource := resourceUser()
+	config := map[string]interface{}{
+		"username":  "jdoe",
+		"password":  "Secret123!",
+		"firstname": "Jane",
+		"lastname":  "Doe",
+		"parent":    "ou=People,dc=example,dc=com",
+	}
+
+	// the CN is kept as it is when other attributes change
+	state := testResourceImport(t, resource, "jdoe", conn)
+	config["description"] = "operator"
+	state = testResourceApply(t, resource, state, config, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"name":        "Doe, Jane",
+		"dn":          `cn=Doe\, Jane,ou=People,dc=example,dc=com`,
+		"description": "operator",
+	})
+
+	config["parent"] = "ou=Staff,dc=example,dc=com"
+	state = testResourceApply(t, resource, state, config, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"name": "Doe, Jane",
+		"dn":   `cn=Doe\, Jane,ou=Staff,dc=example,dc=com`,
+	})
+
+	// changing the name renames the user to the full name
+	config["firstname"] = "Janet"
+	state = testResourceApply(t, resource, state, config, conn)
+	testCheckAttributes(t, state, map[string]string{
+		"name": "Janet Doe",
+		"dn":   "cn=Janet Doe,ou=Staff,dc=example,dc=com",
+	})
+
+	testResourceApply(t, resource, state, nil, conn)
+	if state := testResourceRefresh(t, resource, state, conn); state != nil {
+		t.Fatalf("expected the user to be gone, got %v", state)
+	}
+}
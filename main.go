@@ -1,11 +1,22 @@
 package main
 
 import (
-	"terraform-provider-ad/ad"
+	"fmt"
+	"os"
+
 	"github.com/hashicorp/terraform/plugin"
+	"terraform-provider-ad/ad"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		if err := ad.Generate(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	plugin.Serve(&plugin.ServeOpts{
 		ProviderFunc: ad.Provider})
 }